@@ -0,0 +1,225 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// longest plain text body used as a message, anything longer is left to -v
+const maxPlainMessage = 200
+
+// APIError an error response of Enrober, the build service or SSO
+type APIError struct {
+	Status int
+	Code string
+	Message string
+	Details []string
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = strings.ToLower(http.StatusText(e.Status))
+	}
+
+	if len(e.Details) > 0 {
+		msg += " (" + strings.Join(e.Details, "; ") + ")"
+	}
+
+	return msg
+}
+
+// apiErrorBody the error shapes returned by the Shipyard APIs and SSO, which
+// name the message and code differently. Keys are matched case-insensitively.
+type apiErrorBody struct {
+	Message string
+	ErrorMessage string
+	Error json.RawMessage
+	ErrorDescription string `json:"error_description"`
+	Code json.RawMessage
+	ErrorCode json.RawMessage
+	Details json.RawMessage
+	Errors []struct {
+		Message string
+		Code json.RawMessage
+	}
+}
+
+// parseAPIError decodes the body of a failed API call, falling back to the
+// body itself when it is short plain text
+func parseAPIError(status int, body []byte) *APIError {
+	apiErr := &APIError{Status: status, Body: body}
+
+	parsed := apiErrorBody{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		text := strings.TrimSpace(string(body))
+		if text != "" && len(text) <= maxPlainMessage && !strings.HasPrefix(text, "<") {
+			apiErr.Message = strings.Split(text, "\n")[0]
+		}
+
+		return apiErr
+	}
+
+	// "error" is either the message or an object holding it
+	var errText string
+	nested := apiErrorBody{}
+	if json.Unmarshal(parsed.Error, &errText) != nil && json.Unmarshal(parsed.Error, &nested) == nil {
+		parsed.Message = firstOf(parsed.Message, nested.Message)
+		parsed.Code = firstRaw(parsed.Code, nested.Code)
+		parsed.Details = firstRaw(parsed.Details, nested.Details)
+	}
+
+	apiErr.Message = firstOf(parsed.Message, parsed.ErrorMessage, parsed.ErrorDescription, errText)
+	apiErr.Code = rawString(firstRaw(parsed.Code, parsed.ErrorCode))
+	if parsed.ErrorDescription != "" && errText != "" {
+		apiErr.Code = firstOf(apiErr.Code, errText) // OAuth errors, e.g. invalid_grant
+	}
+
+	for _, e := range parsed.Errors {
+		if apiErr.Message == "" {
+			apiErr.Message = e.Message
+			apiErr.Code = firstOf(apiErr.Code, rawString(e.Code))
+		} else if e.Message != "" && e.Message != apiErr.Message {
+			apiErr.Details = append(apiErr.Details, e.Message)
+		}
+	}
+
+	apiErr.Details = append(apiErr.Details, rawDetails(parsed.Details)...)
+	return apiErr
+}
+
+// rawDetails flattens details given as a string, a list or an object
+func rawDetails(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		if text == "" {
+			return nil
+		}
+
+		return []string{text}
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		details := []string{}
+		for _, item := range list {
+			details = append(details, rawString(item))
+		}
+
+		return details
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) == nil {
+		details := []string{}
+		for key, value := range fields {
+			details = append(details, key + ": " + rawString(value))
+		}
+
+		return details
+	}
+
+	return []string{string(raw)}
+}
+
+// rawString a JSON string without its quotes, any other JSON value as is
+func rawString(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+
+	if string(raw) == "null" {
+		return ""
+	}
+
+	return string(raw)
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func firstRaw(values ...json.RawMessage) json.RawMessage {
+	for _, value := range values {
+		if len(value) > 0 && string(value) != "null" {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// resourceError describes a failed call on a resource in terms of what to do
+// about it, e.g. "deployment dep1 already exists in org1:env1; use patch".
+// The parent, the environment or org the resource lives in, may be empty.
+func resourceError(status int, err error, verb string, kind string, name string, parent string) error {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		return checkStatus(status, err, "Failed to %s %s %s%s", verb, kind, name, in(parent))
+	}
+
+	switch {
+	case apiErr.Status == 409 && (verb == "create" || verb == "build"):
+		return newError(exitConflict, "%s %s already exists%s; %s", kind, name, in(parent), conflictHint(kind))
+	case apiErr.Status == 404 && (verb == "create" || verb == "build") && parent != "":
+		return newError(exitNotFound, "%s not found; unable to create %s %s in it", parent, kind, name)
+	case apiErr.Status == 404:
+		return newError(exitNotFound, "%s %s not found%s", kind, name, in(parent))
+	}
+
+	return checkStatus(status, err, "Failed to %s %s %s%s", verb, kind, name, in(parent))
+}
+
+// conflictHint what to do instead of creating a resource that exists already
+func conflictHint(kind string) string {
+	if kind == "image" {
+		return "build it with a new revision"
+	}
+
+	return "use patch"
+}
+
+func in(parent string) string {
+	if parent == "" {
+		return ""
+	}
+
+	return " in " + parent
+}
+
+// describeStatus the message of an API error, or else the status text
+func describeStatus(status int, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("status %d", status)
+}
@@ -0,0 +1,91 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIError(t *testing.T) {
+	tests := []struct {
+		body    string
+		code    string
+		message string
+		details []string
+	}{
+		{`{"message": "Deployment exists", "code": "conflict"}`, "conflict", "Deployment exists", nil},
+		{`{"errorMessage": "Bad image", "errorCode": 42}`, "42", "Bad image", nil},
+		{`{"error": {"message": "Invalid org", "code": "bad_org", "details": ["org1"]}}`, "bad_org", "Invalid org", []string{"org1"}},
+		{`{"error": "invalid_grant", "error_description": "Bad credentials"}`, "invalid_grant", "Bad credentials", nil},
+		{`{"errors": [{"message": "first", "code": "c1"}, {"message": "second"}]}`, "c1", "first", []string{"second"}},
+		{`{"message": "Invalid", "details": {"replicas": "must be positive"}}`, "", "Invalid", []string{"replicas: must be positive"}},
+		{"environment not found\nat line 2", "", "environment not found", nil},
+		{"<html><body>Bad Gateway</body></html>", "", "", nil},
+		{strings.Repeat("x", maxPlainMessage+1), "", "", nil},
+	}
+
+	for _, test := range tests {
+		apiErr := parseAPIError(400, []byte(test.body))
+		if apiErr.Code != test.code || apiErr.Message != test.message || !reflect.DeepEqual(apiErr.Details, test.details) {
+			t.Errorf("parseAPIError(%q) = %q/%q/%q, expected %q/%q/%q", test.body,
+				apiErr.Code, apiErr.Message, apiErr.Details, test.code, test.message, test.details)
+		}
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	if msg := parseAPIError(502, []byte("<html></html>")).Error(); msg != "bad gateway" {
+		t.Errorf("expected the status text without a message, got %q", msg)
+	}
+
+	apiErr := &APIError{Status: 400, Message: "Invalid", Details: []string{"a", "b"}}
+	if msg := apiErr.Error(); msg != "Invalid (a; b)" {
+		t.Errorf("expected the details after the message, got %q", msg)
+	}
+}
+
+func TestResourceError(t *testing.T) {
+	tests := []struct {
+		status  int
+		verb    string
+		kind    string
+		parent  string
+		message string
+		code    int
+	}{
+		{409, "create", "deployment", "org1:env1", "deployment dep1 already exists in org1:env1; use patch", exitConflict},
+		{409, "build", "image", "org1", "image dep1 already exists in org1; build it with a new revision", exitConflict},
+		{404, "create", "deployment", "org1:env1", "org1:env1 not found; unable to create deployment dep1 in it", exitNotFound},
+		{404, "delete", "deployment", "org1:env1", "deployment dep1 not found in org1:env1", exitNotFound},
+		{404, "get", "environment", "", "environment dep1 not found", exitNotFound},
+		{500, "get", "deployment", "", "Failed to get deployment dep1: boom (status 500)", exitServer},
+	}
+
+	for _, test := range tests {
+		err := resourceError(test.status, &APIError{Status: test.status, Message: "boom"}, test.verb, test.kind, "dep1", test.parent)
+		if err.Error() != test.message || exitCode(err) != test.code {
+			t.Errorf("resourceError(%d, %s) = %q exiting %d, expected %q exiting %d", test.status, test.verb,
+				err, exitCode(err), test.message, test.code)
+		}
+	}
+
+	other := errors.New("connection refused")
+	if err := resourceError(0, other, "get", "deployment", "dep1", ""); err != other {
+		t.Errorf("expected errors other than API ones as is, got %v", err)
+	}
+}
@@ -16,10 +16,6 @@ package cmd
 
 import (
 	"net/http"
-	"io"
-	"os"
-	"log"
-	"fmt"
 
 	"github.com/spf13/cobra"
 )
@@ -34,49 +30,33 @@ returning all available information.
 Example of use:
 
 $ shipyardctl get applications --org org1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
-		RequireOrgName()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		if err := RequireOrgName(cmd); err != nil {
+			return err
+		}
 		MakeBuildPath()
 
-		status := getApplications()
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := getApplications()
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
-		}
+		status, err := retryIfUnauthorized(getApplications)
+		return checkStatus(status, err, "Failed to retrieve the applications of %s", orgName)
 	},
 }
 
-func getApplications() int {
+func getApplications() (int, error) {
 	req, err := http.NewRequest("GET", clusterTarget + basePath, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, printResponse(response)
 }
 
 func init() {
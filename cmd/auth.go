@@ -0,0 +1,277 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/30x/shipyardctl/utils"
+)
+
+var allContexts bool
+var revoke bool
+
+var authCmd = &cobra.Command{
+	Use:   "auth <sub-command>",
+	Short: "authentication based commands",
+	Long: `Supports inspecting the credentials shipyardctl is using.
+
+Example of use:
+
+$ shipyardctl auth status`,
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "shows who you are logged in as",
+	Long: `Prints the user, issuer, scopes and expiry read from the auth token in use,
+along with the context, cluster and SSO target it belongs to. Fails when there
+is no token or it has expired.
+
+Example of use:
+
+$ shipyardctl auth status
+
+$ shipyardctl whoami`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !printAuthStatus() {
+			return authError("Not logged in.")
+		}
+
+		return nil
+	},
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "shows who you are logged in as",
+	Long: `Shorthand for 'shipyardctl auth status'.
+
+Example of use:
+
+$ shipyardctl whoami`,
+	RunE: authStatusCmd.RunE,
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "removes stored credentials",
+	Long: `Removes the credentials stored for the current context, or for every
+context with --all-contexts. With --revoke, the tokens are revoked at the SSO
+target of each context first, so that copies of them stop working too. The
+credentials are removed even when a revocation fails, and the command then fails.
+
+Example of use:
+
+$ shipyardctl logout
+
+$ shipyardctl logout --all-contexts --revoke`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := requireConfigFile(); err != nil {
+			return err
+		}
+
+		contexts := []utils.Context{}
+		if allContexts {
+			contexts = config.Contexts
+		} else if current := config.GetCurrentContext(); current != nil {
+			contexts = append(contexts, *current)
+		}
+
+		failed := 0
+		if revoke {
+			for _, con := range contexts {
+				creds, err := config.GetCredentials(con.Name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to read the tokens of context %s: %v\n", con.Name, err)
+					failed++
+					continue
+				} else if creds.Token == "" {
+					continue
+				}
+
+				if err = revokeTokens(con, creds); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to revoke the tokens of context %s: %v\n", con.Name, err)
+					failed++
+				} else {
+					fmt.Printf("Revoked the tokens of context %s\n", con.Name)
+				}
+			}
+		}
+
+		var err error
+		if allContexts {
+			err = config.ClearAllUserInfo()
+		} else {
+			err = config.ClearUserInfo(config.CurrentContext)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to remove credentials: %v", err)
+		}
+
+		for _, con := range contexts {
+			fmt.Printf("Logged out of context %s\n", con.Name)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("Failed to revoke the tokens of %d context(s)", failed)
+		}
+
+		return nil
+	},
+}
+
+// printAuthStatus describes the token in use without prompting for a login,
+// reporting whether it is usable
+func printAuthStatus() bool {
+	token := authToken
+	source := "--token flag"
+	if token == "" {
+		if token = os.Getenv("APIGEE_TOKEN"); token != "" {
+			source = "APIGEE_TOKEN environment variable"
+		} else if config != nil {
+			token = config.GetCurrentToken()
+			source = "config file"
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	if config != nil {
+		fmt.Fprintf(w, "Context:\t%s\n", config.CurrentContext)
+	}
+	fmt.Fprintf(w, "Cluster:\t%s\n", clusterTarget)
+	fmt.Fprintf(w, "SSO:\t%s\n", sso_target)
+
+	if token == "" {
+		fmt.Fprintf(w, "Status:\tnot logged in\n")
+		return false
+	}
+
+	fmt.Fprintf(w, "Token from:\t%s\n", source)
+
+	claims, err := utils.DecodeToken(token)
+	if err != nil {
+		fmt.Fprintf(w, "Status:\tunable to read token: %v\n", err)
+		return false
+	}
+
+	user := claims.UserName
+	if user == "" {
+		user = claims.Email
+	}
+	fmt.Fprintf(w, "User:\t%s\n", user)
+	fmt.Fprintf(w, "Issuer:\t%s\n", claims.Issuer)
+	fmt.Fprintf(w, "Client:\t%s\n", claims.ClientID)
+	fmt.Fprintf(w, "Scopes:\t%s\n", strings.Join(claims.Scope, " "))
+
+	if claims.ExpiresAt == 0 {
+		fmt.Fprintf(w, "Expires:\tunknown\n")
+		return true
+	}
+
+	expiry := claims.Expiry()
+	remaining := expiry.Sub(time.Now()) / time.Second * time.Second
+	if remaining <= 0 {
+		fmt.Fprintf(w, "Expires:\t%s (expired %s ago)\n", expiry.Local().Format(time.RFC1123), -remaining)
+		if source == "config file" && config.GetCurrentRefreshToken() != "" {
+			fmt.Fprintf(w, "Status:\texpired, will be refreshed on next use\n")
+			return true
+		}
+
+		fmt.Fprintf(w, "Status:\texpired\n")
+		return false
+	}
+
+	fmt.Fprintf(w, "Expires:\t%s (in %s)\n", expiry.Local().Format(time.RFC1123), remaining)
+	fmt.Fprintf(w, "Status:\tlogged in\n")
+	return true
+}
+
+// revokeTokens revokes the access token of the context, and its refresh token when
+// it is a JWT, at the SSO target of the context with the TLS settings of the context
+func revokeTokens(con utils.Context, creds utils.Credentials) error {
+	client, err := contextClient(&con)
+	if err != nil {
+		return err
+	}
+
+	ssoTarget := con.ClusterInfo.SSO
+	if ssoTarget == "" {
+		return fmt.Errorf("context has no SSO target")
+	}
+
+	if err = revokeToken(client, ssoTarget, creds.Token, creds.Token); err != nil {
+		return err
+	}
+
+	if _, err = utils.DecodeToken(creds.RefreshToken); err == nil {
+		return revokeToken(client, ssoTarget, creds.Token, creds.RefreshToken)
+	} else if creds.RefreshToken != "" { // opaque, it would show in logs as its own id
+		logV(logInfo, "refresh token is opaque, not revoked", "context", con.Name)
+	}
+
+	return nil
+}
+
+// revokeToken asks the SSO target to revoke the token, identified by its jti claim,
+// authorized by the access token
+func revokeToken(client *http.Client, ssoTarget string, accessToken string, token string) error {
+	claims, err := utils.DecodeToken(token)
+	if err != nil {
+		return err
+	}
+
+	if claims.ID == "" {
+		return fmt.Errorf("token has no jti claim")
+	}
+
+	req, err := http.NewRequest("DELETE", ssoTarget + "/oauth/token/revoke/" + claims.ID, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer " + accessToken)
+
+	response, err := sendRequestWith(client, req)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode != 200 && response.StatusCode != 401 { // 401: already revoked or expired
+		return fmt.Errorf("SSO responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authStatusCmd)
+	RootCmd.AddCommand(whoamiCmd)
+
+	RootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().BoolVar(&allContexts, "all-contexts", false, "Remove the credentials of every context")
+	logoutCmd.Flags().BoolVar(&revoke, "revoke", false, "Revoke the tokens at the SSO target before removing them")
+}
@@ -0,0 +1,557 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// BackupManifest describes the contents of an environment backup archive
+type BackupManifest struct {
+	Version int
+	EnvironmentName string
+	Context string
+	Cluster string
+	CreatedAt time.Time
+	Deployments []string
+	Images []string
+}
+
+// environmentSnapshot holds the API representation of an environment,
+// all of its deployments and the image revisions they reference
+type environmentSnapshot struct {
+	Manifest BackupManifest
+	Environment json.RawMessage
+	Deployments map[string]json.RawMessage
+	Images map[string]json.RawMessage
+}
+
+type reportEntry struct {
+	Resource string
+	Result string
+	Detail string
+}
+
+// restoreResult records what restoreEnvironment did, so callers can report or undo it
+type restoreResult struct {
+	Report []reportEntry
+	CreatedEnvironment bool
+	CreatedDeployments []string
+	PatchedDeployments []Deployment // as they were before being patched
+	Conflicts []string // deployments skipped because they already exist
+	Failed bool
+}
+
+const backupFormatVersion = 1
+
+var backupOutput string
+var restoreEnvName string
+var overwrite bool
+var forceBackup bool
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [command]",
+	Short: "saves a Shipyard artifact to a local archive",
+	Long: `This command, when paired with the proper subcommand, will save the
+respective artifact to a local archive that can later be restored.`,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [command]",
+	Short: "recreates a Shipyard artifact from a local archive",
+	Long: `This command, when paired with the proper subcommand, will recreate the
+respective artifact from an archive made with 'shipyardctl backup'.`,
+}
+
+var backupEnvironmentCmd = &cobra.Command{
+	Use:   "environment <environmentName>",
+	Short: "saves an environment and its deployments to an archive",
+	Long: `Given the name of an active environment, this will save the environment,
+all of its deployments and the information of every image revision they reference
+to a gzipped tar archive. An existing archive is only replaced with --force.
+
+Example of use:
+$ shipyardctl backup environment org1:env1 -o backup.tar.gz`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
+
+		if len(args) == 0 {
+			return usageError(cmd, "Missing required arg <environmentName>")
+		}
+
+		envName = args[0]
+		if backupOutput == "" {
+			backupOutput = strings.Replace(envName, ":", "_", -1) + ".tar.gz"
+		}
+
+		if _, err := os.Stat(backupOutput); err == nil && !forceBackup {
+			return newError(exitConflict, "%s already exists, use --force to replace it", backupOutput)
+		}
+
+		snap, err := snapshotEnvironment(envName)
+		if err != nil {
+			return err
+		}
+
+		err = snap.writeArchive(backupOutput)
+		if err != nil {
+			return fmt.Errorf("Failed to write backup: %v", err)
+		}
+
+		fmt.Printf("Backed up %s with %d deployment(s) and %d image(s) to %s\n",
+			envName, len(snap.Deployments), len(snap.Images), backupOutput)
+		return nil
+	},
+}
+
+var restoreEnvironmentCmd = &cobra.Command{
+	Use:   "environment <backupFile>",
+	Short: "recreates an environment and its deployments from an archive",
+	Long: `Given an archive made with 'shipyardctl backup environment', this will
+recreate the environment and all of its deployments. The environment is restored
+under its original name unless --env is given, and against the current context
+unless --context is given.
+
+An environment that already exists is left as is. A deployment that already exists
+is reported as a conflict and skipped, unless --overwrite is given, and the command
+then exits with the conflict status once the rest is restored. Image revisions
+cannot be rebuilt from a backup, so any that are missing are reported.
+
+Example of use:
+$ shipyardctl restore environment backup.tar.gz
+
+$ shipyardctl restore environment backup.tar.gz --env org1:env2 --context e2e`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return usageError(cmd, "Missing required arg <backupFile>")
+		}
+
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+
+		rules, err := parseHostRewrites()
+		if err != nil {
+			return usageError(cmd, "%v", err)
+		}
+
+		snap, err := readArchive(args[0])
+		if err != nil {
+			return fmt.Errorf("Failed to read backup: %v", err)
+		}
+
+		env, deployments, err := snap.decode()
+		if err != nil {
+			return fmt.Errorf("Failed to read backup: %v", err)
+		}
+
+		envName = snap.Manifest.EnvironmentName
+		if restoreEnvName != "" {
+			envName = restoreEnvName
+		}
+
+		for i := range env.HostNames {
+			env.HostNames[i] = rewriteHosts(env.HostNames[i], rules)
+		}
+
+		for i := range deployments {
+			deployments[i].PublicHosts = rewriteHosts(deployments[i].PublicHosts, rules)
+			deployments[i].PrivateHosts = rewriteHosts(deployments[i].PrivateHosts, rules)
+		}
+
+		result, err := restoreEnvironment(env, deployments, envName)
+		printReport(result.Report)
+
+		if err != nil {
+			return err
+		} else if result.Failed {
+			return fmt.Errorf("Restore of %s failed", envName)
+		} else if len(result.Conflicts) > 0 {
+			return newError(exitConflict, "%d deployment(s) of %s already exist and were skipped, use --overwrite to patch them", len(result.Conflicts), envName)
+		}
+
+		return nil
+	},
+}
+
+// snapshotEnvironment retrieves the environment, its deployments and the
+// image revisions they reference from the current cluster target
+func snapshotEnvironment(envName string) (*environmentSnapshot, error) {
+	snap := &environmentSnapshot{
+		Deployments: map[string]json.RawMessage{},
+		Images: map[string]json.RawMessage{},
+	}
+
+	body, status, err := fetchAuthorized(enroberPath + "/" + envName)
+	if err = checkStatus(status, err, "Unable to retrieve environment %s", envName); err != nil {
+		return nil, err
+	}
+
+	snap.Environment = body
+
+	body, status, err = fetchAuthorized(enroberPath + "/" + envName + "/deployments")
+	if err = checkStatus(status, err, "Unable to retrieve the deployments of %s", envName); err != nil {
+		return nil, err
+	}
+
+	raw := []json.RawMessage{}
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, js := range raw {
+		dep := Deployment{}
+		err = json.Unmarshal(js, &dep)
+		if err != nil {
+			return nil, err
+		}
+
+		snap.Deployments[dep.DeploymentName] = js
+		snap.Manifest.Deployments = append(snap.Manifest.Deployments, dep.DeploymentName)
+
+		ref, ok := parsePtsUrl(dep.PtsUrl)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: PTS URL of %s does not refer to a Shipyard image, skipping it\n", dep.DeploymentName)
+			continue
+		}
+
+		if _, seen := snap.Images[ref.String()]; seen {
+			continue
+		}
+
+		body, status, err = fetchAuthorized(ref.Path())
+		if err != nil {
+			return nil, err
+		} else if status != 200 {
+			fmt.Fprintf(os.Stderr, "Warning: image %s used by %s could not be retrieved (status %d)\n", ref, dep.DeploymentName, status)
+			continue
+		}
+
+		snap.Images[ref.String()] = body
+		snap.Manifest.Images = append(snap.Manifest.Images, ref.String())
+	}
+
+	snap.Manifest.Version = backupFormatVersion
+	snap.Manifest.EnvironmentName = envName
+	snap.Manifest.Cluster = clusterTarget
+	snap.Manifest.CreatedAt = time.Now().UTC()
+	if config != nil {
+		snap.Manifest.Context = config.CurrentContext
+	}
+
+	return snap, nil
+}
+
+// decode unpacks the environment and deployments held by the snapshot
+func (snap *environmentSnapshot) decode() (Environment, []Deployment, error) {
+	env := Environment{}
+	err := json.Unmarshal(snap.Environment, &env)
+	if err != nil {
+		return env, nil, err
+	}
+
+	deployments := []Deployment{}
+	for _, name := range snap.Manifest.Deployments {
+		dep := Deployment{}
+		err = json.Unmarshal(snap.Deployments[name], &dep)
+		if err != nil {
+			return env, nil, err
+		}
+
+		deployments = append(deployments, dep)
+	}
+
+	return env, deployments, nil
+}
+
+// writeArchive saves the snapshot as a gzipped tar archive at the given path,
+// replacing an existing file only with --force
+func (snap *environmentSnapshot) writeArchive(archivePath string) error {
+	flags := os.O_WRONLY|os.O_CREATE|os.O_TRUNC
+	if !forceBackup {
+		flags |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(archivePath, flags, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	archive := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(snap.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		"manifest.json": manifest,
+		"environment.json": snap.Environment,
+	}
+
+	for name, js := range snap.Deployments {
+		files[path.Join("deployments", name + ".json")] = js
+	}
+
+	for ref, js := range snap.Images {
+		files[path.Join("images", ref + ".json")] = js
+	}
+
+	for name, data := range files {
+		header := &tar.Header{
+			Name: name,
+			Mode: 0600,
+			Size: int64(len(data)),
+			ModTime: snap.Manifest.CreatedAt,
+		}
+
+		if err = archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err = archive.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return err
+	}
+
+	if err = gz.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// readArchive loads a snapshot from an archive made by writeArchive
+func readArchive(archivePath string) (*environmentSnapshot, error) {
+	data, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &environmentSnapshot{
+		Deployments: map[string]json.RawMessage{},
+		Images: map[string]json.RawMessage{},
+	}
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		contents, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case header.Name == "manifest.json":
+			err = json.Unmarshal(contents, &snap.Manifest)
+			if err != nil {
+				return nil, err
+			}
+		case header.Name == "environment.json":
+			snap.Environment = contents
+		case strings.HasPrefix(header.Name, "deployments/"):
+			snap.Deployments[strings.TrimSuffix(path.Base(header.Name), ".json")] = contents
+		case strings.HasPrefix(header.Name, "images/"):
+			snap.Images[strings.TrimSuffix(strings.TrimPrefix(header.Name, "images/"), ".json")] = contents
+		}
+	}
+
+	if snap.Manifest.Version == 0 || snap.Environment == nil {
+		return nil, fmt.Errorf("%s is not a shipyardctl backup", archivePath)
+	}
+
+	if snap.Manifest.Version > backupFormatVersion {
+		return nil, fmt.Errorf("%s was made by a newer shipyardctl (format version %d)", archivePath, snap.Manifest.Version)
+	}
+
+	return snap, nil
+}
+
+// restoreEnvironment recreates the environment and deployments as targetEnv
+// on the current cluster target. Existing deployments are only patched with --overwrite.
+func restoreEnvironment(env Environment, deployments []Deployment, targetEnv string) (restoreResult, error) {
+	defer keepResponsesQuiet()()
+
+	result := restoreResult{}
+	add := func(resource string, outcome string, detail string) {
+		result.Report = append(result.Report, reportEntry{resource, outcome, detail})
+	}
+
+	envResource := "environment " + targetEnv
+
+	_, status, err := fetchAuthorized(enroberPath + "/" + targetEnv)
+	if err != nil {
+		return result, err
+	}
+
+	switch {
+	case status == 200:
+		add(envResource, "exists", "left unchanged")
+	case status == 404:
+		status, err = retryIfUnauthorized(func() (int, error) {
+			return createEnv(targetEnv, env.HostNames)
+		})
+
+		if unexpected(err) {
+			return result, err
+		} else if !isSuccess(status) {
+			add(envResource, "failed", "creation failed: " + describeStatus(status, err))
+			result.Failed = true
+			return result, nil
+		}
+
+		result.CreatedEnvironment = true
+		add(envResource, "created", strings.Join(env.HostNames, " "))
+	default:
+		add(envResource, "failed", fmt.Sprintf("lookup returned status %d", status))
+		result.Failed = true
+		return result, nil
+	}
+
+	checked := map[string]bool{}
+	for _, dep := range deployments {
+		if ref, ok := parsePtsUrl(dep.PtsUrl); ok && !checked[ref.String()] {
+			checked[ref.String()] = true
+			_, status, err = fetchAuthorized(ref.Path())
+			if err != nil {
+				return result, err
+			} else if status == 404 {
+				add("image " + ref.String(), "missing", "rebuild it with 'shipyardctl create image'")
+			}
+		}
+
+		depResource := "deployment " + dep.DeploymentName
+		name := dep.DeploymentName
+
+		var existing []byte
+		existing, status, err = fetchAuthorized(enroberPath + "/" + targetEnv + "/deployments/" + name)
+		if err != nil {
+			return result, err
+		}
+
+		if status == 200 {
+			if !overwrite {
+				add(depResource, "conflict", "already exists, use --overwrite to patch it")
+				result.Conflicts = append(result.Conflicts, name)
+				continue
+			}
+
+			// keep the deployment as it is, so that a rollback can patch it back
+			previous := Deployment{}
+			if err = json.Unmarshal(existing, &previous); err != nil {
+				add(depResource, "failed", "unable to read it before patching: " + err.Error())
+				result.Failed = true
+				continue
+			}
+			result.PatchedDeployments = append(result.PatchedDeployments, previous)
+
+			js, err := json.Marshal(DeploymentPatch{dep.PublicHosts, dep.PrivateHosts, dep.Replicas, dep.PtsUrl, dep.EnvVars})
+			if err != nil {
+				add(depResource, "failed", err.Error())
+				result.Failed = true
+				continue
+			}
+
+			status, err = retryIfUnauthorized(func() (int, error) {
+				return patchDeployment(targetEnv, name, string(js))
+			})
+
+			if unexpected(err) {
+				return result, err
+			} else if !isSuccess(status) {
+				add(depResource, "failed", "patch failed: " + describeStatus(status, err))
+				result.Failed = true
+			} else {
+				add(depResource, "patched", dep.PtsUrl)
+			}
+
+			continue
+		}
+
+		status, err = retryIfUnauthorized(func() (int, error) {
+			return createDeployment(targetEnv, name, dep.PublicHosts, dep.PrivateHosts, dep.Replicas, dep.PtsUrl, dep.EnvVars)
+		})
+
+		if unexpected(err) {
+			return result, err
+		} else if !isSuccess(status) {
+			add(depResource, "failed", "creation failed: " + describeStatus(status, err))
+			result.Failed = true
+		} else {
+			result.CreatedDeployments = append(result.CreatedDeployments, name)
+			add(depResource, "created", dep.PtsUrl)
+		}
+	}
+
+	return result, nil
+}
+
+// printReport prints a table of what happened to each resource
+func printReport(report []reportEntry) {
+	fmt.Println("\nReport:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tRESULT\tDETAIL")
+	for _, entry := range report {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Resource, entry.Result, entry.Detail)
+	}
+	w.Flush()
+}
+
+func init() {
+	RootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupEnvironmentCmd)
+	backupEnvironmentCmd.Flags().StringVarP(&backupOutput, "output", "o", "", "Path of the archive to write, defaults to <org>_<env>.tar.gz")
+	backupEnvironmentCmd.Flags().BoolVar(&forceBackup, "force", false, "Replace the archive if it already exists")
+
+	RootCmd.AddCommand(restoreCmd)
+	restoreCmd.AddCommand(restoreEnvironmentCmd)
+	restoreEnvironmentCmd.Flags().StringVar(&restoreEnvName, "env", "", "Name of the environment to restore into, defaults to the original name")
+	restoreEnvironmentCmd.Flags().StringSliceVarP(&hostRewrites, "rewrite-host", "r", []string{}, "Host rewrite rule \"from=to\" applied to environment and deployment hosts")
+	restoreEnvironmentCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Patch deployments that already exist instead of skipping them")
+}
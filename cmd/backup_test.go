@@ -0,0 +1,134 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/30x/shipyardctl/fake"
+)
+
+// useFakeCluster points the API calls of this process at a fake Shipyard,
+// with a token it accepts, returning the fake and a function restoring the targets
+func useFakeCluster(t *testing.T) (*fake.Server, func()) {
+	shipyard := fake.NewServer()
+	server := httptest.NewServer(shipyard)
+
+	previousCluster, previousSSO, previousToken := clusterTarget, sso_target, authToken
+	clusterTarget, sso_target, authToken = server.URL, server.URL, "test-token"
+
+	return shipyard, func() {
+		server.Close()
+		clusterTarget, sso_target, authToken = previousCluster, previousSSO, previousToken
+	}
+}
+
+func TestRestoreEnvironment(t *testing.T) {
+	shipyard, cleanup := useFakeCluster(t)
+	defer cleanup()
+
+	env := Environment{EnvironmentName: "org1:env1", HostNames: []string{"env1.example.com"}}
+	deployments := []Deployment{
+		{DeploymentName: "dep1", PublicHosts: "dep1.example.com", Replicas: 2, PtsUrl: "https://pts.example.com/1"},
+		{DeploymentName: "dep2", Replicas: 1, PtsUrl: "https://pts.example.com/2", EnvVars: []EnvVar{{"A", "b"}}},
+	}
+
+	result, err := restoreEnvironment(env, deployments, "org1:env2")
+	if err != nil || result.Failed {
+		t.Fatalf("restoreEnvironment failed: %v %+v", err, result.Report)
+	}
+
+	if !result.CreatedEnvironment || !reflect.DeepEqual(result.CreatedDeployments, []string{"dep1", "dep2"}) || len(result.Conflicts) > 0 {
+		t.Errorf("restoreEnvironment gave %+v", result)
+	}
+
+	dep, ok := shipyard.GetDeployment("org1:env2", "dep2")
+	if !ok || dep.PtsURL != "https://pts.example.com/2" || len(dep.EnvVars) != 1 {
+		t.Errorf("restoreEnvironment created %+v", dep)
+	}
+}
+
+func TestRestoreEnvironmentReportsConflicts(t *testing.T) {
+	shipyard, cleanup := useFakeCluster(t)
+	defer cleanup()
+
+	shipyard.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	shipyard.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep1", Replicas: 1, PtsURL: "https://pts.example.com/old"})
+
+	deployments := []Deployment{
+		{DeploymentName: "dep1", Replicas: 2, PtsUrl: "https://pts.example.com/new"},
+		{DeploymentName: "dep2", Replicas: 1, PtsUrl: "https://pts.example.com/2"},
+	}
+
+	result, err := restoreEnvironment(Environment{EnvironmentName: "org1:env1"}, deployments, "org1:env1")
+	if err != nil || result.Failed {
+		t.Fatalf("restoreEnvironment failed: %v %+v", err, result.Report)
+	}
+
+	if result.CreatedEnvironment || !reflect.DeepEqual(result.Conflicts, []string{"dep1"}) || !reflect.DeepEqual(result.CreatedDeployments, []string{"dep2"}) {
+		t.Errorf("restoreEnvironment gave %+v", result)
+	}
+
+	if dep, _ := shipyard.GetDeployment("org1:env1", "dep1"); dep.PtsURL != "https://pts.example.com/old" {
+		t.Errorf("restoreEnvironment changed the conflicting deployment to %+v", dep)
+	}
+}
+
+func TestWriteArchiveKeepsExistingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipyardctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "backup.tar.gz")
+	if err = ioutil.WriteFile(archivePath, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := &environmentSnapshot{
+		Manifest:    BackupManifest{Version: backupFormatVersion, EnvironmentName: "org1:env1", Deployments: []string{"dep1"}},
+		Environment: json.RawMessage(`{"environmentName":"org1:env1"}`),
+		Deployments: map[string]json.RawMessage{"dep1": json.RawMessage(`{"deploymentName":"dep1"}`)},
+		Images:      map[string]json.RawMessage{},
+	}
+
+	if err = snap.writeArchive(archivePath); err == nil {
+		t.Fatalf("writeArchive replaced an existing archive")
+	}
+
+	forceBackup = true
+	defer func() { forceBackup = false }()
+
+	if err = snap.writeArchive(archivePath); err != nil {
+		t.Fatalf("writeArchive --force: %v", err)
+	}
+
+	read, err := readArchive(archivePath)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+
+	env, deployments, err := read.decode()
+	if err != nil || env.EnvironmentName != "org1:env1" || len(deployments) != 1 || deployments[0].DeploymentName != "dep1" {
+		t.Errorf("the archive decoded to %+v %+v: %v", env, deployments, err)
+	}
+}
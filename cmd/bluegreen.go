@@ -0,0 +1,328 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var imagePts string
+var bluegreenPublicHost string
+var bluegreenPrivateHost string
+var bluegreenReplicas int64
+var readyTimeout time.Duration
+var rollback bool
+
+var colors = []string{"blue", "green"}
+
+// bluegreenCmd represents the bluegreen command
+var bluegreenCmd = &cobra.Command{
+	Use:   "bluegreen <environmentName> <appName>",
+	Short: "deploys a new image by switching between two deployments",
+	Long: `An application is run as two deployments, <appName>-blue and <appName>-green,
+of which only the live one owns the public host. This command creates or updates
+the idle deployment with the image given by --image-pts, waits until it is ready
+and then moves the public host over to it.
+
+The first time, when neither deployment exists yet, the public host must be given
+with --public-host; it is assigned once the first deployment is ready. With --rollback,
+the public host is moved back to the other deployment without changing any image.
+
+A deployment is ready once it passes the same HTTP checks as 'shipyardctl test
+deployment' on its private hosts, which must be reachable from where the command
+runs. Give the idle deployment its own with --private-host, and match something
+only the new image answers with, such as its version, with --body-regex, as the
+replicas running the previous image answer too while it rolls out.
+
+Example of use:
+$ shipyardctl bluegreen org1:env1 example --image-pts "https://pts.url.com" --public-host "org1-env1.apigee.net"
+
+$ shipyardctl bluegreen org1:env1 example --rollback`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
+
+		if len(args) < 2 {
+			return usageError(cmd, "Missing required args")
+		}
+
+		envName = args[0]
+		appName := args[1]
+
+		if !rollback && imagePts == "" {
+			return usageError(cmd, "Missing required flag '--image-pts'")
+		}
+
+		if readyTimeout <= 0 {
+			return usageError(cmd, "--timeout must be positive, a deployment only goes live once it is ready")
+		}
+
+		vars, err := parseEnvVars()
+		if err != nil {
+			return usageError(cmd, "%v", err)
+		}
+
+		// find out which color currently owns the public host
+		deployments := map[string]*Deployment{}
+		live := ""
+		for _, color := range colors {
+			name := appName + "-" + color
+
+			var dep *Deployment
+			status, err := retryIfUnauthorized(func() (status int, err error) {
+				dep, status, err = fetchDeployment(envName, name)
+				return
+			})
+
+			if err != nil {
+				return err
+			} else if status != 200 && status != 404 {
+				return statusError(status, "Unable to retrieve deployment %s", name)
+			}
+
+			deployments[color] = dep
+			if dep != nil && dep.PublicHosts != "" {
+				if live != "" {
+					return newError(exitConflict, "Both %s-blue and %s-green have a public host, refusing to switch.", appName, appName)
+				}
+
+				live = color
+			}
+		}
+
+		if live == "" {
+			if rollback {
+				return fmt.Errorf("Neither deployment has a public host, there is nothing to roll back.")
+			}
+
+			// first deployment, it goes live once it is ready
+			if bluegreenPublicHost == "" {
+				return usageError(cmd, "Neither deployment has a public host yet. Provide one with '--public-host'.")
+			}
+
+			firstName := appName + "-" + colors[0]
+			if err := requirePrivateHost(firstName, deployments[colors[0]]); err != nil {
+				return err
+			}
+
+			if err := deployColor(firstName, deployments[colors[0]], vars); err != nil {
+				return err
+			}
+
+			if err := waitForColor(envName, firstName, "nothing is live yet"); err != nil {
+				return err
+			}
+
+			status, err := setPublicHosts(envName, firstName, bluegreenPublicHost)
+			if err = checkStatus(status, err, "Failed to assign %s to %s", bluegreenPublicHost, firstName); err != nil {
+				return err
+			}
+
+			fmt.Printf("\n%s is live on %s\n", firstName, bluegreenPublicHost)
+			return nil
+		}
+
+		idle := colors[0]
+		if live == colors[0] {
+			idle = colors[1]
+		}
+
+		liveName := appName + "-" + live
+		idleName := appName + "-" + idle
+		publicHost := deployments[live].PublicHosts
+
+		if rollback {
+			if deployments[idle] == nil {
+				return newError(exitNotFound, "%s does not exist, there is nothing to roll back to.", idleName)
+			}
+		} else {
+			if err := requirePrivateHost(idleName, deployments[idle]); err != nil {
+				return err
+			}
+
+			if bluegreenReplicas == 0 {
+				bluegreenReplicas = deployments[live].Replicas
+			}
+
+			if err := deployColor(idleName, deployments[idle], vars); err != nil {
+				return err
+			}
+		}
+
+		if err := waitForColor(envName, idleName, liveName + " is still live"); err != nil {
+			return err
+		}
+
+		if err := switchPublicHost(envName, liveName, idleName, publicHost); err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%s is now live on %s, %s is idle\n", idleName, publicHost, liveName)
+		return nil
+	},
+}
+
+// deployColor creates the named deployment with the new image and environment
+// variables, or patches it if it exists already, without a public host
+func deployColor(name string, current *Deployment, vars []EnvVar) error {
+	if current == nil {
+		replicas := bluegreenReplicas
+		if replicas == 0 {
+			replicas = 1
+		}
+
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return createDeployment(envName, name, "", bluegreenPrivateHost, replicas, imagePts, vars)
+		})
+
+		return checkStatus(status, err, "Failed to create deployment %s", name)
+	}
+
+	js, err := json.Marshal(DeploymentPatch{"", bluegreenPrivateHost, bluegreenReplicas, imagePts, vars})
+	if err != nil {
+		return err
+	}
+
+	status, err := retryIfUnauthorized(func() (int, error) {
+		return patchDeployment(envName, name, string(js))
+	})
+
+	return checkStatus(status, err, "Failed to patch deployment %s", name)
+}
+
+// requirePrivateHost fails before anything is changed when the deployment
+// would have no private host to check its readiness on
+func requirePrivateHost(name string, current *Deployment) error {
+	if bluegreenPrivateHost != "" || (current != nil && strings.TrimSpace(current.PrivateHosts) != "") {
+		return nil
+	}
+
+	return newError(exitUsage, "%s has no private host to check its readiness on. Give it one with '--private-host'.", name)
+}
+
+// waitForColor waits until the deployment is ready, describing what
+// is live when it does not become ready
+func waitForColor(envName string, depName string, live string) error {
+	fmt.Printf("Waiting for %s to be ready...\n", depName)
+	ready, err := waitUntilReady(envName, depName, readyTimeout)
+	if err != nil {
+		return err
+	} else if !ready {
+		return fmt.Errorf("%s did not become ready within %s, %s.", depName, readyTimeout, live)
+	}
+
+	return nil
+}
+
+// waitUntilReady checks the private hosts of the deployment until they pass the
+// HTTP checks or the timeout runs out, printing the failed checks in that case
+func waitUntilReady(envName string, depName string, timeout time.Duration) (bool, error) {
+	var dep *Deployment
+	status, err := retryIfUnauthorized(func() (status int, err error) {
+		dep, status, err = fetchDeployment(envName, depName)
+		return
+	})
+
+	if err = checkStatus(status, err, "Unable to retrieve deployment %s in %s", depName, envName); err != nil {
+		return false, err
+	}
+
+	hosts := strings.Fields(dep.PrivateHosts)
+	if len(hosts) == 0 {
+		return false, newError(exitUsage, "%s has no private host to check its readiness on. Give it one with '--private-host'.", depName)
+	}
+
+	check, err := newSmokeCheck(dep, hosts)
+	if err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		results, ready := check.run(0)
+		if ready {
+			return true, nil
+		}
+
+		if time.Now().After(deadline) {
+			printSmokeResults(results)
+			return false, nil
+		}
+
+		pause(retryInterval)
+	}
+}
+
+// setPublicHosts patches only the public hosts of a deployment,
+// which may be empty to take them away
+func setPublicHosts(envName string, depName string, hosts string) (int, error) {
+	js, err := json.Marshal(map[string]string{"publicHosts": hosts})
+	if err != nil {
+		return 0, err
+	}
+
+	return retryIfUnauthorized(func() (int, error) {
+		return patchDeployment(envName, depName, string(js))
+	})
+}
+
+// switchPublicHost moves the public host from one deployment to the other.
+// The host is added to the new deployment before it is taken from the old one,
+// so that it is served throughout; only when Enrober refuses to have the host on
+// both at once is it released first.
+func switchPublicHost(envName string, from string, to string, host string) error {
+	status, err := setPublicHosts(envName, to, host)
+	if !unexpected(err) && status == 409 {
+		status, err = setPublicHosts(envName, from, "")
+		if err = checkStatus(status, err, "Failed to release %s from %s", host, from); err != nil {
+			return err
+		}
+
+		status, err = setPublicHosts(envName, to, host)
+		if err = checkStatus(status, err, "Failed to assign %s to %s, giving it back to %s", host, to, from); err != nil {
+			setPublicHosts(envName, from, host)
+			return err
+		}
+
+		return nil
+	}
+
+	if err = checkStatus(status, err, "Failed to assign %s to %s. %s is still live", host, to, from); err != nil {
+		return err
+	}
+
+	status, err = setPublicHosts(envName, from, "")
+	return checkStatus(status, err, "Failed to release %s from %s, it is now served by both deployments", host, from)
+}
+
+func init() {
+	RootCmd.AddCommand(bluegreenCmd)
+	bluegreenCmd.Flags().StringVar(&imagePts, "image-pts", "", "PTS URL of the image to deploy")
+	bluegreenCmd.Flags().StringVar(&bluegreenPublicHost, "public-host", "", "Public host to serve the application on, only needed for the first deployment")
+	bluegreenCmd.Flags().StringVar(&bluegreenPrivateHost, "private-host", "", "Private host of the new deployment")
+	bluegreenCmd.Flags().Int64Var(&bluegreenReplicas, "replicas", 0, "Number of replicas, defaults to those of the live deployment")
+	bluegreenCmd.Flags().StringSliceVarP(&envVars, "env", "e", []string{}, "Environment variables to set in the new deployment")
+	bluegreenCmd.Flags().DurationVar(&readyTimeout, "timeout", 5 * time.Minute, "How long to wait for the new deployment to be ready")
+	bluegreenCmd.Flags().DurationVar(&retryInterval, "retry-interval", 5 * time.Second, "Time to wait between checks of the new deployment")
+	addCheckFlags(bluegreenCmd, "private hosts")
+	bluegreenCmd.Flags().BoolVar(&rollback, "rollback", false, "Move the public host back to the other deployment")
+}
@@ -46,11 +46,9 @@ environment built and deployed on Shipyard.
 Example of use:
 
 $ shipyardctl create bundle exampleName`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
-			fmt.Println("Missing required arg.")
-			fmt.Println("Usage:\t\n"+cmd.Use)
-			return
+			return usageError(cmd, "Missing required arg.")
 		}
 
 		name := args[0]
@@ -58,13 +56,10 @@ $ shipyardctl create bundle exampleName`,
 		// make a temp dir
 		tmpdir, err := ioutil.TempDir("", orgName+"_"+envName)
 		if err != nil {
-			fmt.Println("Failed to make a temporary directory")
-			return
+			return fmt.Errorf("Failed to make a temporary directory: %v", err)
 		}
 
-		if verbose {
-			fmt.Println("Creating tmpdir at: "+tmpdir)
-		}
+		logV(logInfo, "creating tmpdir", "path", tmpdir)
 
 		defer os.RemoveAll(tmpdir)
 
@@ -72,31 +67,31 @@ $ shipyardctl create bundle exampleName`,
 		dir := filepath.Join(tmpdir, "apiproxy")
 		err = os.Mkdir(dir, fileMode)
 
-		if verbose {
-			fmt.Println("Creating folder 'apiproxy' at: "+dir)
+		logV(logInfo, "creating folder", "path", dir)
+		if err = checkError(err, "Unable to make root apiproxy dir"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make root apiproxy dir")
 
 		proxiesDirPath := filepath.Join(dir, "proxies")
 		err = os.Mkdir(proxiesDirPath, fileMode)
-		if verbose {
-			fmt.Println("Creating subfolder 'proxies' at: "+proxiesDirPath)
+		logV(logInfo, "creating folder", "path", proxiesDirPath)
+		if err = checkError(err, "Unable to make proxies dir"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make proxies dir")
 
 		targetsDirPath := filepath.Join(dir, "targets")
 		err = os.Mkdir(targetsDirPath, fileMode)
-		if verbose {
-			fmt.Println("Creating subfolder 'targets' at: "+targetsDirPath)
+		logV(logInfo, "creating folder", "path", targetsDirPath)
+		if err = checkError(err, "Unable to make targets dir"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make targets dir")
 
 		policiesDirPath := filepath.Join(dir, "policies")
 		err = os.Mkdir(policiesDirPath, fileMode)
-		if verbose {
-			fmt.Println("Creating subfolder 'policies' at: "+policiesDirPath)
+		logV(logInfo, "creating folder", "path", policiesDirPath)
+		if err = checkError(err, "Unable to make policies dir"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make policies dir")
 
 		// bundle user info for templates
 		if base == "" {
@@ -108,10 +103,10 @@ $ shipyardctl create bundle exampleName`,
 		// example.xml --> ./apiproxy/
 		proxy_xml, err := os.Create(filepath.Join(dir, name+".xml"))
 		err = proxy_xml.Chmod(fileMode)
-		if verbose {
-			fmt.Println("Creating file '"+name+".xml'")
+		logV(logInfo, "creating file", "path", name + ".xml")
+		if err = checkError(err, "Unable to make "+name+".xml file"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make "+name+".xml file")
 
 		proxyTmpl, err := template.New("PROXY").Parse(PROXY_XML)
 		if err != nil { panic(err) }
@@ -121,10 +116,10 @@ $ shipyardctl create bundle exampleName`,
 		// AddCors.xml --> ./apiproxy/policies
 		add_cors_xml, err := os.Create(filepath.Join(policiesDirPath, "AddCors.xml"))
 		err = add_cors_xml.Chmod(fileMode)
-		if verbose {
-			fmt.Println("Creating file 'policies/AddCors.xml'")
+		logV(logInfo, "creating file", "path", "policies/AddCors.xml")
+		if err = checkError(err, "Unable to make AddCors.xml file"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make AddCors.xml file")
 
 		addCors, err := template.New("ADD_CORS").Parse(ADD_CORS)
 		if err != nil { panic(err) }
@@ -134,17 +129,17 @@ $ shipyardctl create bundle exampleName`,
 		// default.xml --> ./apiproxy/proxies && ./apiproxy/targets
 		proxy_default_xml, err := os.Create(filepath.Join(proxiesDirPath, "default.xml"))
 		err = proxy_default_xml.Chmod(fileMode)
-		if verbose {
-			fmt.Println("Creating file 'proxies/default.xml'")
+		logV(logInfo, "creating file", "path", "proxies/default.xml")
+		if err = checkError(err, "Unable to make default.xml file"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make default.xml file")
 
 		target_default_xml, err := os.Create(filepath.Join(targetsDirPath, "default.xml"))
 		err = target_default_xml.Chmod(fileMode)
-		if verbose {
-			fmt.Println("Creating file 'targets/default.xml'")
+		logV(logInfo, "creating file", "path", "targets/default.xml")
+		if err = checkError(err, "Unable to make default.xml file"); err != nil {
+			return err
 		}
-		checkError(err, "Unable to make default.xml file")
 
 		proxyEndpoint, err := template.New("PROXY_ENDPOINT").Parse(PROXY_ENDPOINT)
 		if err != nil { panic(err) }
@@ -162,39 +157,37 @@ $ shipyardctl create bundle exampleName`,
 		// move zip to designated savePath
 		if savePath != "" {
 			err = os.Rename(zipDir, filepath.Join(savePath, name+".zip"))
-			if verbose {
-				fmt.Println("Moving proxy folder to "+savePath)
+			logV(logInfo, "moving proxy folder", "path", savePath)
+			if err = checkError(err, "Unable to move apiproxy to target save directory"); err != nil {
+				return err
 			}
-			checkError(err, "Unable to move apiproxy to target save directory")
 		} else { // move apiproxy from tmpdir to cwd
 			cwd, err := os.Getwd()
 			err = os.Rename(zipDir, filepath.Join(cwd, name+".zip"))
-			if verbose {
-				fmt.Println("Moving proxy folder to CWD")
+			logV(logInfo, "moving proxy folder to the working directory")
+			if err = checkError(err, "Unable to move apiproxy bundle to cwd"); err != nil {
+				return err
 			}
-			checkError(err, "Unable to move apiproxy bundle to cwd")
 		}
 
-		if verbose {
-				fmt.Println("Deleting tmpdir")
-			}
+		logV(logInfo, "deleting tmpdir")
+
+		return nil
 	},
 }
 
 
-func checkError(err error, customMsg string) {
-	if err != nil {
-		if customMsg != "" {
-			fmt.Println(customMsg)
-		}
-
-		fmt.Printf("\n%v\n", err)
-		os.Exit(1)
+func checkError(err error, customMsg string) error {
+	if err != nil && customMsg != "" {
+		return fmt.Errorf("%s: %v", customMsg, err)
 	}
+
+	return err
 }
 
 func init() {
 	createCmd.AddCommand(bundleCmd)
+	withoutConfig[bundleCmd] = true
 	bundleCmd.Flags().StringVarP(&savePath, "save", "s", "", "Save path for proxy bundle")
 	bundleCmd.Flags().StringVarP(&base, "basePath", "b", "", "Proxy base path. Defaults to /")
 	bundleCmd.Flags().StringVarP(&publicPath, "publicPath", "p", "/", "Application public path. Defaults to /")
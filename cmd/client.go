@@ -0,0 +1,274 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/30x/shipyardctl/utils"
+)
+
+// first and longest wait between retries of a failed request
+const (
+	retryBackoff = 500 * time.Millisecond
+	maxRetryBackoff = 10 * time.Second
+)
+
+var requestTimeout time.Duration
+var totalTimeout time.Duration
+var httpRetries int
+
+// when the command started, the total timeout counts from here
+var startedAt = time.Now()
+
+// transports of the contexts used so far, by context name, so that
+// connections are reused within a context
+var transports = map[string]*http.Transport{}
+
+// sendRequest sends the request with the client of the current context, retrying
+// idempotent requests that fail to connect or get a 502, 503 or 504 response
+func sendRequest(req *http.Request) (*http.Response, error) {
+	client, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return sendRequestWith(client, req)
+}
+
+// sendRequestWith sends the request with the given client, e.g. that of another
+// context than the current one, retrying it as sendRequest does
+func sendRequestWith(client *http.Client, req *http.Request) (*http.Response, error) {
+	var err error
+
+	// keep the body around to send it again
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	printCurlCommand(req, body)
+	if leftOut(req, body) {
+		return dryRunResponse(req, body), nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		client.Timeout, err = timeoutOf(req)
+		if err != nil {
+			return nil, err
+		}
+
+		logRequest(req, body)
+		start := time.Now()
+		response, err := roundTrip(client, req, body)
+		logResponse(req, response, err, time.Since(start))
+		if attempt >= httpRetries || !isIdempotent(req.Method) || !isTransient(response, err) {
+			return response, err
+		}
+
+		wait := backoff(attempt, response)
+		if remaining := timeRemaining(); totalTimeout > 0 && wait >= remaining {
+			return response, err
+		}
+
+		reason := describeFailure(response, err)
+		if response != nil {
+			response.Body.Close()
+		}
+
+		logV(logInfo, "retrying API call", "method", req.Method, "url", redactURL(req.URL), "reason", reason, "wait", wait / time.Millisecond * time.Millisecond)
+
+		pause(wait)
+	}
+}
+
+// httpClient the client for the current context, going through HTTPS_PROXY or
+// HTTP_PROXY and using the TLS settings of the context
+func httpClient() (*http.Client, error) {
+	var context *utils.Context
+	if config != nil {
+		context = config.GetCurrentContext()
+	}
+
+	return contextClient(context)
+}
+
+// contextClient the client for the given context, or with the default
+// TLS settings for none
+func contextClient(context *utils.Context) (*http.Client, error) {
+	name := ""
+	if context != nil {
+		name = context.Name
+	}
+
+	transport, ok := transports[name]
+	if !ok {
+		transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout: 30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns: 10,
+			IdleConnTimeout: 90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+
+		if context != nil {
+			tlsConfig, err := context.ClusterInfo.TLSConfig()
+			if err != nil {
+				return nil, err
+			}
+
+			transport.TLSClientConfig = tlsConfig
+		}
+
+		transports[name] = transport
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// timeoutOf the time the request may take, cut short by the total timeout
+func timeoutOf(req *http.Request) (time.Duration, error) {
+	timeout := requestTimeout
+	if totalTimeout <= 0 {
+		return timeout, nil
+	}
+
+	remaining := timeRemaining()
+	if remaining <= 0 {
+		return 0, networkError(fmt.Errorf("%s %s: total timeout of %s exceeded", req.Method, redactURL(req.URL), totalTimeout))
+	}
+
+	if timeout <= 0 || remaining < timeout {
+		timeout = remaining
+	}
+
+	return timeout, nil
+}
+
+// timeRemaining the time left before the total timeout
+func timeRemaining() time.Duration {
+	return startedAt.Add(totalTimeout).Sub(time.Now())
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// isTransient reports whether the request failed in a way that may go away on its own,
+// such as a cluster being upgraded: a timeout, a refused or reset connection, or a 502,
+// 503 or 504. Other failures, e.g. of TLS verification or a cancelled call, are final.
+func isTransient(response *http.Response, err error) bool {
+	if err != nil {
+		return isTransientError(err)
+	}
+
+	switch response.StatusCode {
+	case 502, 503, 504:
+		return true
+	}
+
+	return false
+}
+
+// isTransientError reports whether the error is a timeout or a refused or reset connection
+func isTransientError(err error) bool {
+	for {
+		switch cause := err.(type) {
+		case *replayedError:
+			return cause.transient
+		case *url.Error:
+			err = cause.Err
+		case *net.OpError:
+			if cause.Timeout() {
+				return true
+			}
+			err = cause.Err
+		case *os.SyscallError:
+			err = cause.Err
+		case syscall.Errno:
+			return isConnectionError(cause)
+		case net.Error:
+			return cause.Timeout()
+		default: // a connection closed by the server mid-response was reset too
+			return err == io.EOF || err == io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// backoff the time to wait before the given retry: exponential, with jitter so
+// that clients failing together do not retry together. Retry-After is honored.
+func backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			wait := time.Duration(seconds) * time.Second
+			if wait <= maxRetryBackoff {
+				return wait
+			}
+
+			return maxRetryBackoff
+		}
+	}
+
+	wait := retryBackoff << uint(attempt)
+	if wait > maxRetryBackoff || wait <= 0 {
+		wait = maxRetryBackoff
+	}
+
+	return wait / 2 + time.Duration(rand.Int63n(int64(wait / 2) + 1))
+}
+
+func describeFailure(response *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("status %d", response.StatusCode)
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+
+	RootCmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", 2 * time.Minute, "Maximum time a single API call may take, 0 for no limit")
+	RootCmd.PersistentFlags().DurationVar(&totalTimeout, "total-timeout", 0, "Maximum time all API calls of the command may take, 0 for no limit")
+	RootCmd.PersistentFlags().IntVar(&httpRetries, "http-retries", 3, "Number of times to retry idempotent API calls failing with a network error or a 502, 503 or 504")
+}
@@ -0,0 +1,113 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// refusedError dials a port nobody listens on anymore
+func refusedError(t *testing.T) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = http.Get("http://" + addr)
+	if err == nil {
+		t.Fatalf("expected connecting to %s to fail", addr)
+	}
+
+	return err
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"refused", refusedError(t), true},
+		{"timeout", &url.Error{Op: "Get", URL: "https://api", Err: &net.OpError{Op: "read", Err: timeoutError{}}}, true},
+		{"reset mid-response", &url.Error{Op: "Get", URL: "https://api", Err: io.ErrUnexpectedEOF}, true},
+		{"replayed", &replayedError{"refused", true}, true},
+		{"replayed final", &replayedError{"bad certificate", false}, false},
+		{"tls", &url.Error{Op: "Get", URL: "https://api", Err: x509.UnknownAuthorityError{}}, false},
+		{"other", errors.New("cancelled"), false},
+	}
+
+	for _, test := range tests {
+		if transient := isTransient(nil, test.err); transient != test.transient {
+			t.Errorf("%s: isTransient(%v) = %v, expected %v", test.name, test.err, transient, test.transient)
+		}
+	}
+
+	for status, transient := range map[int]bool{502: true, 503: true, 504: true, 500: false, 429: false, 404: false} {
+		if got := isTransient(&http.Response{StatusCode: status}, nil); got != transient {
+			t.Errorf("isTransient(status %d) = %v, expected %v", status, got, transient)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		wait := retryBackoff << uint(attempt)
+		if wait > maxRetryBackoff || wait <= 0 {
+			wait = maxRetryBackoff
+		}
+
+		for n := 0; n < 10; n++ {
+			if got := backoff(attempt, nil); got < wait/2 || got > wait {
+				t.Fatalf("backoff(%d) = %s, expected between %s and %s", attempt, got, wait/2, wait)
+			}
+		}
+	}
+}
+
+func TestBackoffHonorsRetryAfter(t *testing.T) {
+	tests := map[string]time.Duration{
+		"3":     3 * time.Second,
+		"0":     0,
+		"3600":  maxRetryBackoff,
+		"never": -1,
+	}
+
+	for header, expected := range tests {
+		response := &http.Response{Header: http.Header{"Retry-After": []string{header}}}
+		got := backoff(0, response)
+		if expected < 0 {
+			if got > retryBackoff {
+				t.Errorf("backoff with Retry-After %q = %s, expected the default backoff", header, got)
+			}
+		} else if got != expected {
+			t.Errorf("backoff with Retry-After %q = %s, expected %s", header, got, expected)
+		}
+	}
+}
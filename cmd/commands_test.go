@@ -0,0 +1,872 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/30x/shipyardctl/fake"
+	"github.com/30x/shipyardctl/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// set in the environment of the test binary run as shipyardctl by testCluster.command
+const runAsCLI = "SHIPYARDCTL_TEST_RUN_AS_CLI"
+
+// TestMain runs the test binary as shipyardctl when asked to by testCluster.run, so
+// that every command runs in a process of its own, with fresh flags and state
+func TestMain(m *testing.M) {
+	if os.Getenv(runAsCLI) == "1" {
+		RootCmd.SetArgs(os.Args[1:])
+		Execute()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testCluster a fake Shipyard served with httptest, and a home directory with
+// a config whose current context, test, points at it
+type testCluster struct {
+	t       *testing.T
+	fake    *fake.Server
+	servers []*httptest.Server
+	home    string
+}
+
+func newTestCluster(t *testing.T) *testCluster {
+	home, err := ioutil.TempDir("", "shipyardctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCluster{t: t, home: home}
+	var url string
+	c.fake, url = c.serveFake(httptest.NewServer)
+
+	config := fmt.Sprintf(`apiVersion: %s
+currentcontext: test
+contexts:
+- name: test
+  clusterinfo:
+    name: test
+    cluster: %s
+    sso: %s
+`, utils.ConfigAPIVersion, url, url)
+
+	if err = os.MkdirAll(filepath.Join(home, utils.ShipyardctlConfigDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	c.writeConfig(config)
+	return c
+}
+
+// configPath the path of the config file in the home directory
+func (c *testCluster) configPath() string {
+	return filepath.Join(c.home, utils.ShipyardctlConfigDir, utils.ShipyardctlConfigFileName)
+}
+
+func (c *testCluster) writeConfig(config string) {
+	if err := ioutil.WriteFile(c.configPath(), []byte(config), 0600); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testCluster) readConfig() string {
+	data, err := ioutil.ReadFile(c.configPath())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// serveFake serves another fake Shipyard with the given httptest constructor,
+// returning it and its URL
+func (c *testCluster) serveFake(serve func(http.Handler) *httptest.Server) (*fake.Server, string) {
+	shipyard := fake.NewServer()
+	shipyard.Users["me@example.com"] = "secret"
+
+	server := serve(shipyard)
+	c.servers = append(c.servers, server)
+
+	return shipyard, server.URL
+}
+
+// addContext adds a context of the given name pointing at another fake Shipyard, and returns it
+func (c *testCluster) addContext(name string) *fake.Server {
+	shipyard, url := c.serveFake(httptest.NewServer)
+	c.mustRun("config", "new-context", name, "--cluster-target", url, "--sso-target", url)
+
+	return shipyard
+}
+
+// addTLSContext adds a context of the given name pointing at another fake Shipyard
+// served over TLS, whose certificate only the context trusts
+func (c *testCluster) addTLSContext(name string) *fake.Server {
+	shipyard, url := c.serveFake(httptest.NewTLSServer)
+
+	certificate := c.servers[len(c.servers)-1].TLS.Certificates[0].Certificate[0]
+	caPath := filepath.Join(c.home, name+"-ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0600); err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.mustRun("config", "new-context", name, "--cluster-target", url, "--sso-target", url, "--certificate-authority", caPath)
+	return shipyard
+}
+
+func (c *testCluster) Close() {
+	for _, server := range c.servers {
+		server.Close()
+	}
+
+	os.RemoveAll(c.home)
+}
+
+// command shipyardctl with the given arguments, run in the home directory with
+// an environment holding nothing that could change its config
+func (c *testCluster) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = c.home
+	cmd.Env = []string{runAsCLI + "=1", "HOME=" + c.home, "USERPROFILE=" + c.home}
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		switch {
+		case name == "HOME", name == "USERPROFILE", name == "CLUSTER_TARGET", name == "SSO_LOGIN_URL",
+			strings.HasPrefix(name, "APIGEE_"), strings.HasPrefix(name, "SHIPYARDCTL_"):
+			continue
+		}
+
+		cmd.Env = append(cmd.Env, variable)
+	}
+
+	return cmd
+}
+
+// run runs shipyardctl with the given arguments, returning its stdout, stderr and exit code
+func (c *testCluster) run(args ...string) (string, string, int) {
+	cmd := c.command(args...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err := cmd.Run()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.Sys().(interface{ ExitStatus() int }).ExitStatus()
+	} else if err != nil {
+		c.t.Fatalf("shipyardctl %s: %v", strings.Join(args, " "), err)
+	}
+
+	return stdout.String(), stderr.String(), code
+}
+
+// mustRun runs shipyardctl, failing the test unless the command succeeds, and returns its stdout
+func (c *testCluster) mustRun(args ...string) string {
+	stdout, stderr, code := c.run(args...)
+	if code != 0 {
+		c.t.Fatalf("shipyardctl %s exited with %d:\n%s", strings.Join(args, " "), code, stderr)
+	}
+
+	return stdout
+}
+
+// expectExit runs shipyardctl, failing the test unless the command exits with the given code
+func (c *testCluster) expectExit(code int, args ...string) {
+	_, stderr, got := c.run(args...)
+	if got != code {
+		c.t.Errorf("shipyardctl %s exited with %d, expected %d:\n%s", strings.Join(args, " "), got, code, stderr)
+	}
+}
+
+func (c *testCluster) login() {
+	c.mustRun("login", "-u", "me@example.com", "-p", "secret")
+}
+
+// savedToken the access token of the current context saved in the config
+func (c *testCluster) savedToken() string {
+	config := utils.Config{}
+	if err := yaml.Unmarshal([]byte(c.readConfig()), &config); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return config.GetCurrentToken()
+}
+
+// savedTokenOf the access token of the named context saved in the config
+func (c *testCluster) savedTokenOf(name string) string {
+	config := utils.Config{}
+	if err := yaml.Unmarshal([]byte(c.readConfig()), &config); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for _, con := range config.Contexts {
+		if con.Name == name {
+			return con.UserInfo.Token
+		}
+	}
+
+	return ""
+}
+
+func TestLoginAndRefresh(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+
+	c.expectExit(exitAuth, "login", "-u", "me@example.com", "-p", "wrong")
+	if c.savedToken() != "" {
+		t.Fatalf("a failed login saved a token")
+	}
+
+	c.login()
+	first := c.savedToken()
+	claims, err := utils.DecodeToken(first)
+	if err != nil || claims.UserName != "me@example.com" {
+		t.Fatalf("login saved %q: %v", first, err)
+	}
+
+	c.mustRun("auth", "status")
+
+	// tokens about to expire are refreshed before they are used
+	c.fake.TokenLifetime = 30 * time.Second
+	c.login()
+	expiring := c.savedToken()
+
+	c.expectExit(exitNotFound, "get", "environment", "org1:none")
+	if refreshed := c.savedToken(); refreshed == expiring || refreshed == "" {
+		t.Errorf("the expiring token was not refreshed")
+	}
+
+	c.mustRun("logout", "--revoke")
+	if c.savedToken() != "" {
+		t.Errorf("logout left the token in the config")
+	}
+}
+
+func TestEnvironmentCommands(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.login()
+
+	c.mustRun("create", "environment", "org1:env1", "host1.example.com")
+	env, ok := c.fake.GetEnvironment("org1:env1")
+	if !ok || strings.Join(env.HostNames, " ") != "host1.example.com" {
+		t.Fatalf("create environment made %+v", env)
+	}
+
+	c.expectExit(exitConflict, "create", "environment", "org1:env1", "host1.example.com")
+
+	c.mustRun("patch", "environment", "org1:env1", "host1.example.com", "host2.example.com")
+	if env, _ = c.fake.GetEnvironment("org1:env1"); len(env.HostNames) != 2 {
+		t.Errorf("patch environment left host names %v", env.HostNames)
+	}
+
+	if stdout := c.mustRun("get", "environment", "org1:env1"); !strings.Contains(stdout, "host2.example.com") {
+		t.Errorf("get environment printed %s", stdout)
+	}
+
+	c.mustRun("delete", "environment", "org1:env1", "--dry-run")
+	if _, ok = c.fake.GetEnvironment("org1:env1"); !ok {
+		t.Fatalf("delete environment --dry-run deleted it")
+	}
+
+	c.mustRun("delete", "environment", "org1:env1", "--yes")
+	if _, ok = c.fake.GetEnvironment("org1:env1"); ok {
+		t.Errorf("delete environment left it in place")
+	}
+
+	c.expectExit(exitNotFound, "get", "environment", "org1:env1")
+}
+
+func TestDryRunUnsupported(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.login()
+
+	// commands that wait for their changes can't pretend to make them
+	_, stderr, code := c.run("bluegreen", "org1:env1", "dep1", "--dry-run")
+	if code != exitUsage || !strings.Contains(stderr, "--dry-run is not supported") {
+		t.Errorf("bluegreen --dry-run exited with %d:\n%s", code, stderr)
+	}
+}
+
+func TestDeploymentCommands(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.login()
+
+	stdout := c.mustRun("create", "deployment", "org1:env1", "dep1", "pub.example.com", "priv.example.com", "2", "https://pts.example.com", "-e", "DB_PASSWORD=pw", "--dry-run")
+	if _, ok := c.fake.GetDeployment("org1:env1", "dep1"); ok || !strings.Contains(stdout, "REDACTED") || strings.Contains(stdout, "pw\"") {
+		t.Fatalf("create deployment --dry-run printed\n%s", stdout)
+	}
+
+	c.mustRun("create", "deployment", "org1:env1", "dep1", "pub.example.com", "priv.example.com", "2", "https://pts.example.com", "-e", "DB_PASSWORD=pw")
+	dep, ok := c.fake.GetDeployment("org1:env1", "dep1")
+	if !ok || dep.Replicas != 2 || len(dep.EnvVars) != 1 || dep.EnvVars[0].Value != "pw" {
+		t.Fatalf("create deployment made %+v", dep)
+	}
+
+	c.expectExit(exitConflict, "create", "deployment", "org1:env1", "dep1", "pub.example.com", "priv.example.com", "2", "https://pts.example.com")
+
+	c.mustRun("patch", "deployment", "org1:env1", "dep1", `{"replicas": 3}`)
+	if dep, _ = c.fake.GetDeployment("org1:env1", "dep1"); dep.Replicas != 3 {
+		t.Errorf("patch deployment left %d replicas", dep.Replicas)
+	}
+
+	if stdout = c.mustRun("get", "deployment", "org1:env1", "dep1"); !strings.Contains(stdout, `"replicas":3`) {
+		t.Errorf("get deployment printed %s", stdout)
+	}
+
+	if stdout = c.mustRun("get", "logs", "org1:env1", "dep1"); !strings.Contains(stdout, "dep1") {
+		t.Errorf("get logs printed %s", stdout)
+	}
+
+	c.mustRun("delete", "deployment", "org1:env1", "dep1", "--yes")
+	if _, ok = c.fake.GetDeployment("org1:env1", "dep1"); ok {
+		t.Errorf("delete deployment left it in place")
+	}
+
+	c.expectExit(exitNotFound, "get", "deployment", "org1:env1", "dep1")
+}
+
+func TestImageCommands(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.login()
+
+	zipped := &bytes.Buffer{}
+	writer := zip.NewWriter(zipped)
+	file, _ := writer.Create("app/package.json")
+	file.Write([]byte(`{"name": "example"}`))
+	writer.Close()
+
+	zipPath := filepath.Join(c.home, "app.zip")
+	if err := ioutil.WriteFile(zipPath, zipped.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := c.mustRun("create", "image", "example", "1", "9000:/example", zipPath, "--org", "org1", "--dry-run")
+	if _, ok := c.fake.GetImage("org1", "example", "1"); ok || !strings.Contains(stdout, "file=@app.zip") {
+		t.Fatalf("create image --dry-run printed\n%s", stdout)
+	}
+
+	c.mustRun("create", "image", "example", "1", "9000:/example", zipPath, "--org", "org1")
+	image, ok := c.fake.GetImage("org1", "example", "1")
+	if !ok || image.PublicPath != "9000:/example" || image.Size != int64(zipped.Len()) {
+		t.Fatalf("create image built %+v", image)
+	}
+
+	if stdout = c.mustRun("get", "image", "example", "1", "--org", "org1"); !strings.Contains(stdout, `"revision":"1"`) {
+		t.Errorf("get image printed %s", stdout)
+	}
+
+	c.mustRun("delete", "image", "example", "1", "--org", "org1")
+	if _, ok = c.fake.GetImage("org1", "example", "1"); ok {
+		t.Errorf("delete image left it in place")
+	}
+
+	c.expectExit(exitNotFound, "get", "image", "example", "1", "--org", "org1")
+}
+
+func TestGlobalContextFlags(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.login()
+
+	other := c.addContext("other")
+	otherURL := c.servers[len(c.servers)-1].URL
+	other.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.mustRun("login", "-u", "me@example.com", "-p", "secret", "--context", "other")
+
+	c.expectExit(exitNotFound, "get", "environment", "org1:env1")
+	c.mustRun("get", "environment", "org1:env1", "--context", "other")
+	c.mustRun("get", "environment", "org1:env1", "--cluster-target", otherURL)
+	if _, stderr, code := c.run("get", "environment", "org1:env1", "--context", "missing"); code == 0 || !strings.Contains(stderr, "Invalid context name: missing") {
+		t.Errorf("--context of a missing context exited with %d:\n%s", code, stderr)
+	}
+
+	if stdout := c.mustRun("config", "current-context"); strings.TrimSpace(stdout) != "test" {
+		t.Errorf("--context changed the current context to %s", stdout)
+	}
+
+	// another config file, using the other context by default
+	configPath := filepath.Join(c.home, "other-config")
+	config := strings.Replace(c.readConfig(), "currentcontext: test", "currentcontext: other", 1)
+	if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mustRun("get", "environment", "org1:env1", "--config", configPath)
+}
+
+func TestMissingCurrentContext(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.writeConfig(strings.Replace(c.readConfig(), "currentcontext: test", "currentcontext: gone", 1))
+
+	_, stderr, code := c.run("get", "environment", "org1:env1")
+	if code != 1 || !strings.Contains(stderr, "config use-context") {
+		t.Errorf("a missing current context exited with %d:\n%s", code, stderr)
+	}
+
+	if stdout := c.mustRun("config", "get-contexts"); !strings.Contains(stdout, "test") {
+		t.Errorf("config get-contexts printed %s", stdout)
+	}
+
+	c.mustRun("config", "view")
+	c.expectExit(1, "config", "validate")
+	c.expectExit(exitNotFound, "get", "environment", "org1:env1", "--context", "test", "--token", "unchecked")
+
+	c.mustRun("config", "use-context", "test")
+	c.login()
+}
+
+func TestMigrate(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	target := c.addContext("prod")
+
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1", HostNames: []string{"env1.example.com"}})
+	c.fake.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep1", Replicas: 2, PtsURL: "https://pts.example.com/dep1", EnvVars: []fake.EnvVar{{Name: "A", Value: "b"}}})
+	c.fake.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep2", Replicas: 1, PtsURL: "https://pts.example.com/dep2"})
+	target.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	target.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep1", Replicas: 1, PtsURL: "https://pts.example.com/old"})
+
+	c.login()
+	c.mustRun("login", "-u", "me@example.com", "-p", "secret", "--context", "prod")
+
+	// the environment defaults to the one of the source context
+	c.mustRun("config", "set-context", "test", "--env", "org1:env1")
+	c.mustRun("config", "set-context", "prod", "--env", "org1:other")
+
+	stdout, stderr, code := c.run("migrate", "--from-context", "test", "--to-context", "prod")
+	if code != exitConflict || !strings.Contains(stdout, "conflict") || strings.Contains(stdout, "successful") {
+		t.Fatalf("migrating onto an existing deployment exited with %d:\n%s%s", code, stdout, stderr)
+	}
+
+	if _, ok := target.GetDeployment("org1:env1", "dep2"); ok {
+		t.Errorf("the failed migration left dep2 behind")
+	}
+
+	if dep, _ := target.GetDeployment("org1:env1", "dep1"); dep.PtsURL != "https://pts.example.com/old" {
+		t.Errorf("the failed migration changed dep1 to %+v", dep)
+	}
+
+	c.mustRun("migrate", "--from-context", "test", "--to-context", "prod", "--overwrite")
+	dep, _ := target.GetDeployment("org1:env1", "dep1")
+	if dep.PtsURL != "https://pts.example.com/dep1" || len(dep.EnvVars) != 1 {
+		t.Errorf("migrate --overwrite left dep1 as %+v", dep)
+	}
+
+	if _, ok := target.GetDeployment("org1:env1", "dep2"); !ok {
+		t.Errorf("migrate did not create dep2")
+	}
+}
+
+func TestBluegreen(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.login()
+
+	// the application, answering the readiness checks on the private host
+	var mu sync.Mutex
+	answer := http.StatusOK
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(answer)
+	}))
+	defer app.Close()
+	setAnswer := func(status int) {
+		mu.Lock()
+		answer = status
+		mu.Unlock()
+	}
+
+	privateHost := strings.TrimPrefix(app.URL, "http://")
+	bluegreen := func(args ...string) []string {
+		return append([]string{"bluegreen", "org1:env1", "example", "--private-host", privateHost,
+			"--scheme", "http", "--path", "/health", "--retry-interval", "10ms", "--timeout", "500ms"}, args...)
+	}
+
+	publicHosts := func() (string, string) {
+		blue, _ := c.fake.GetDeployment("org1:env1", "example-blue")
+		green, _ := c.fake.GetDeployment("org1:env1", "example-green")
+		return blue.PublicHosts, green.PublicHosts
+	}
+
+	c.expectExit(exitUsage, bluegreen("--image-pts", "https://pts.example.com/1", "--public-host", "app.example.com", "--timeout", "0")...)
+	if _, ok := c.fake.GetDeployment("org1:env1", "example-blue"); ok {
+		t.Fatalf("bluegreen --timeout 0 deployed")
+	}
+
+	c.mustRun(bluegreen("--image-pts", "https://pts.example.com/1", "--public-host", "app.example.com")...)
+	if blue, green := publicHosts(); blue != "app.example.com" || green != "" {
+		t.Fatalf("the first deployment left public hosts %q and %q", blue, green)
+	}
+
+	c.mustRun(bluegreen("--image-pts", "https://pts.example.com/2")...)
+	if blue, green := publicHosts(); blue != "" || green != "app.example.com" {
+		t.Fatalf("the switch left public hosts %q and %q", blue, green)
+	}
+
+	// a deployment that does not become ready does not go live
+	setAnswer(http.StatusServiceUnavailable)
+	stdout, _, code := c.run(bluegreen("--image-pts", "https://pts.example.com/3")...)
+	if code != 1 || !strings.Contains(stdout, "FAIL") {
+		t.Errorf("switching to an unhealthy deployment exited with %d:\n%s", code, stdout)
+	}
+
+	if blue, green := publicHosts(); blue != "" || green != "app.example.com" {
+		t.Errorf("switching to an unhealthy deployment left public hosts %q and %q", blue, green)
+	}
+
+	setAnswer(http.StatusOK)
+	c.mustRun("bluegreen", "org1:env1", "example", "--rollback", "--scheme", "http", "--path", "/health")
+	if blue, green := publicHosts(); blue != "app.example.com" || green != "" {
+		t.Errorf("the rollback left public hosts %q and %q", blue, green)
+	}
+
+	c.expectExit(exitUsage, "bluegreen", "org1:env1", "other", "--image-pts", "https://pts.example.com/1", "--public-host", "other.example.com")
+	if _, ok := c.fake.GetDeployment("org1:env1", "other-blue"); ok {
+		t.Errorf("bluegreen without a private host deployed")
+	}
+}
+
+func TestBluegreenReplayDoesNotWait(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.login()
+
+	// the application only becomes ready on the third check
+	var mu sync.Mutex
+	checks := 0
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if checks++; checks < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer app.Close()
+
+	recording := filepath.Join(c.home, "bluegreen.har")
+	args := []string{"bluegreen", "org1:env1", "example", "--image-pts", "https://pts.example.com/1", "--public-host", "app.example.com",
+		"--private-host", strings.TrimPrefix(app.URL, "http://"), "--scheme", "http", "--retry-interval", "1s", "--timeout", "10s"}
+
+	c.mustRun(append(args, "--record", recording)...)
+
+	started := time.Now()
+	c.mustRun(append(args, "--replay", recording)...)
+	if took := time.Since(started); took >= time.Second {
+		t.Errorf("the replay took %s, waiting between the recorded checks", took)
+	}
+}
+
+func TestLogoutRevokesEveryContext(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.addTLSContext("prod")
+
+	c.login()
+	c.mustRun("login", "-u", "me@example.com", "-p", "secret", "--context", "prod")
+	tokens := map[string]string{"test": c.savedTokenOf("test"), "prod": c.savedTokenOf("prod")}
+
+	stdout := c.mustRun("logout", "--all-contexts", "--revoke")
+	for name, token := range tokens {
+		if !strings.Contains(stdout, "Revoked the tokens of context "+name) {
+			t.Errorf("logout did not revoke the tokens of %s:\n%s", name, stdout)
+		}
+
+		if c.savedTokenOf(name) != "" {
+			t.Errorf("logout left the token of %s in the config", name)
+		}
+
+		c.expectExit(exitAuth, "get", "environment", "org1:env1", "--context", name, "--token", token)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:test"})
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:prod"})
+	c.fake.AddDeployment("org1:test", fake.Deployment{DeploymentName: "dep1", PublicHosts: "dep1-test.example.com",
+		PrivateHosts: "dep1-test.internal", Replicas: 2, PtsURL: "https://pts.example.com/dep1",
+		EnvVars: []fake.EnvVar{{Name: "DB_PASSWORD", Value: "pw"}, {Name: "LOG_LEVEL", Value: "info"}}})
+	c.login()
+
+	c.expectExit(exitUsage, "promote", "org1:test/dep1", "org1:prod", "-e", "BROKEN", "--yes")
+	c.expectExit(exitUsage, "promote", "org1:test", "org1:prod", "--yes")
+	c.expectExit(exitNotFound, "promote", "org1:test/missing", "org1:prod", "--yes")
+
+	stdout := c.mustRun("promote", "org1:test/dep1", "org1:prod", "-r", "-test.=-prod.", "-e", "LOG_LEVEL=warn", "--yes")
+	if strings.Contains(stdout, "pw") || !strings.Contains(stdout, "DB_PASSWORD: (unset) -> "+redacted) {
+		t.Errorf("promote printed\n%s", stdout)
+	}
+
+	dep, ok := c.fake.GetDeployment("org1:prod", "dep1")
+	if !ok || dep.PublicHosts != "dep1-prod.example.com" || dep.PrivateHosts != "dep1-prod.internal" ||
+		dep.PtsURL != "https://pts.example.com/dep1" || len(dep.EnvVars) != 2 || dep.EnvVars[1].Value != "warn" {
+		t.Fatalf("promote created %+v", dep)
+	}
+
+	// the target keeps its own scale when patched
+	c.mustRun("patch", "deployment", "org1:prod", "dep1", `{"replicas": 5}`)
+	c.mustRun("promote", "org1:test/dep1", "org1:prod", "-r", "-test.=-prod.", "--yes")
+	if dep, _ = c.fake.GetDeployment("org1:prod", "dep1"); dep.Replicas != 5 || dep.EnvVars[1].Value != "info" {
+		t.Errorf("promoting again left %+v", dep)
+	}
+
+	if stdout = c.mustRun("promote", "org1:test/dep1", "org1:prod", "-r", "-test.=-prod.", "--yes"); !strings.Contains(stdout, "Nothing to promote") {
+		t.Errorf("promoting a matching deployment printed\n%s", stdout)
+	}
+}
+
+func TestDeleteCascade(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.fake.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep1", Replicas: 1})
+	c.fake.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep2", Replicas: 1})
+	c.login()
+
+	c.expectExit(exitConflict, "delete", "environment", "org1:env1", "--yes")
+	c.expectExit(exitNotFound, "delete", "environment", "org1:missing", "--yes")
+
+	stdout := c.mustRun("delete", "environment", "org1:env1", "--cascade", "--dry-run")
+	if !strings.Contains(stdout, "dep1") || !strings.Contains(stdout, "dep2") {
+		t.Errorf("delete environment --cascade --dry-run printed\n%s", stdout)
+	}
+
+	if _, ok := c.fake.GetDeployment("org1:env1", "dep1"); !ok {
+		t.Fatalf("delete environment --cascade --dry-run deleted dep1")
+	}
+
+	c.mustRun("delete", "deployment", "org1:env1", "--all", "--yes")
+	for _, name := range []string{"dep1", "dep2"} {
+		if _, ok := c.fake.GetDeployment("org1:env1", name); ok {
+			t.Errorf("delete deployment --all left %s", name)
+		}
+	}
+
+	c.fake.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep3", Replicas: 1})
+	c.mustRun("delete", "environment", "org1:env1", "--cascade", "--yes")
+	if _, ok := c.fake.GetEnvironment("org1:env1"); ok {
+		t.Errorf("delete environment --cascade left the environment")
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1", HostNames: []string{"env1.example.com"}})
+	c.fake.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep1", PublicHosts: "dep1.env1.example.com",
+		Replicas: 2, PtsURL: "https://pts.example.com/dep1", EnvVars: []fake.EnvVar{{Name: "A", Value: "b"}}})
+	c.login()
+
+	c.mustRun("backup", "environment", "org1:env1", "-o", "env1.tar.gz")
+	c.expectExit(exitConflict, "backup", "environment", "org1:env1", "-o", "env1.tar.gz")
+	c.mustRun("backup", "environment", "org1:env1", "-o", "env1.tar.gz", "--force")
+
+	c.mustRun("restore", "environment", "env1.tar.gz", "--env", "org1:env2", "-r", "env1.=env2.")
+	env, ok := c.fake.GetEnvironment("org1:env2")
+	if !ok || strings.Join(env.HostNames, " ") != "env2.example.com" {
+		t.Fatalf("restore created environment %+v", env)
+	}
+
+	dep, ok := c.fake.GetDeployment("org1:env2", "dep1")
+	if !ok || dep.PublicHosts != "dep1.env2.example.com" || dep.Replicas != 2 || len(dep.EnvVars) != 1 {
+		t.Fatalf("restore created deployment %+v", dep)
+	}
+
+	// restoring again skips the existing deployment, unless told to overwrite it
+	c.mustRun("patch", "deployment", "org1:env2", "dep1", `{"replicas": 1}`)
+	c.expectExit(exitConflict, "restore", "environment", "env1.tar.gz", "--env", "org1:env2", "-r", "env1.=env2.")
+	if dep, _ = c.fake.GetDeployment("org1:env2", "dep1"); dep.Replicas != 1 {
+		t.Errorf("a conflicting restore patched %+v", dep)
+	}
+
+	c.mustRun("restore", "environment", "env1.tar.gz", "--env", "org1:env2", "-r", "env1.=env2.", "--overwrite")
+	if dep, _ = c.fake.GetDeployment("org1:env2", "dep1"); dep.Replicas != 2 {
+		t.Errorf("restore --overwrite left %+v", dep)
+	}
+
+	c.expectExit(exitError, "restore", "environment", "missing.tar.gz")
+}
+
+func TestSmokeTests(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.login()
+
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer app.Close()
+	host := strings.TrimPrefix(app.URL, "http://")
+
+	check := []string{"--scheme", "http", "--path", "/health", "--retries", "0"}
+	create := append([]string{"create", "deployment", "org1:env1", "dep1", host, "dep1.internal", "1", "https://pts.example.com/dep1", "--smoke"}, check...)
+	if stdout := c.mustRun(create...); !strings.Contains(stdout, "dep1 is healthy") {
+		t.Errorf("create deployment --smoke printed\n%s", stdout)
+	}
+
+	c.mustRun(append([]string{"test", "deployment", "org1:env1", "dep1", "--body-regex", `"ok"`}, check...)...)
+
+	stdout, _, code := c.run("test", "deployment", "org1:env1", "dep1", "--scheme", "http", "--path", "/missing", "--retries", "1", "--retry-interval", "10ms")
+	if code != exitError || !strings.Contains(stdout, "FAIL") || !strings.Contains(stdout, "expected status 200") {
+		t.Errorf("testing a failing path exited with %d:\n%s", code, stdout)
+	}
+
+	c.expectExit(exitError, append([]string{"test", "deployment", "org1:env1", "dep1", "--body-regex", "down"}, check...)...)
+	c.expectExit(exitUsage, append([]string{"test", "deployment", "org1:env1", "dep1", "--body-regex", "("}, check...)...)
+	c.expectExit(exitNotFound, append([]string{"test", "deployment", "org1:env1", "missing"}, check...)...)
+}
+
+func TestContextCommands(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	c.addContext("other")
+	c.login()
+
+	if stdout := c.mustRun("config", "get-contexts"); !strings.Contains(stdout, "test") || !strings.Contains(stdout, "other") {
+		t.Errorf("get-contexts printed\n%s", stdout)
+	}
+
+	c.mustRun("config", "set-context", "other", "--org", "org1", "--env", "org1:env1", "--client-id", "ci-bot", "--client-secret", "s3cret")
+	config := c.readConfig()
+	if !strings.Contains(config, "org1:env1") || !strings.Contains(config, "ci-bot") || !strings.Contains(config, "s3cret") {
+		t.Errorf("set-context saved\n%s", config)
+	}
+
+	c.mustRun("config", "rename-context", "other", "staging")
+	c.expectExit(exitError, "config", "rename-context", "other", "again")
+	c.mustRun("config", "use-context", "staging")
+	if stdout := c.mustRun("config", "current-context"); strings.TrimSpace(stdout) != "staging" {
+		t.Errorf("current-context printed %s after use-context staging", stdout)
+	}
+
+	c.mustRun("config", "validate")
+
+	// tokens and client secrets move to the encrypted file and back
+	c.mustRun("config", "use-context", "test")
+	cmd := []string{"config", "migrate-credentials", "--to", "encrypted-file", "--key-file", filepath.Join(c.home, "key")}
+	if err := ioutil.WriteFile(filepath.Join(c.home, "key"), []byte("a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	token := c.savedToken()
+	c.mustRun(cmd...)
+	if config = c.readConfig(); strings.Contains(config, token) || strings.Contains(config, "s3cret") {
+		t.Errorf("migrate-credentials left credentials in the config\n%s", config)
+	}
+
+	c.mustRun("get", "environment", "org1:env1") // authorized with the migrated token
+	c.mustRun("config", "migrate-credentials", "--to", "plain")
+	if c.savedToken() != token || !strings.Contains(c.readConfig(), "s3cret") {
+		t.Errorf("migrating back to plain lost credentials\n%s", c.readConfig())
+	}
+
+	c.expectExit(exitError, "config", "delete-context", "test")
+	c.mustRun("config", "delete-context", "staging")
+	if strings.Contains(c.readConfig(), "staging") {
+		t.Errorf("delete-context left the context in the config")
+	}
+}
+
+func TestStatusAndIdentityCommands(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+	c.fake.AddImage("org1", fake.Image{Name: "example", Revision: "1", PublicPath: "9000:/example"})
+
+	if stdout := c.mustRun("version"); !strings.Contains(stdout, "Version: "+Version) {
+		t.Errorf("version printed %s", stdout)
+	}
+
+	c.expectExit(exitAuth, "whoami")
+	c.login()
+
+	if stdout := c.mustRun("whoami"); !strings.Contains(stdout, "me@example.com") {
+		t.Errorf("whoami printed %s", stdout)
+	}
+
+	if stdout := c.mustRun("get", "status"); !strings.Contains(stdout, "Build service status") || !strings.Contains(stdout, "Deployment service status") {
+		t.Errorf("get status printed %s", stdout)
+	}
+
+	if stdout := c.mustRun("get", "applications", "--org", "org1"); !strings.Contains(stdout, "example") {
+		t.Errorf("get applications printed %s", stdout)
+	}
+}
+
+func TestFakeServerCommand(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.Close()
+
+	server := c.command("dev", "fake-server", "--port", "0", "--user", "me@example.com:secret")
+	stderr, err := server.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		server.Process.Kill()
+		server.Wait()
+	}()
+
+	line, err := bufio.NewReader(stderr).ReadString('\n')
+	if err != nil || !strings.Contains(line, "listening on ") {
+		t.Fatalf("fake-server printed %q: %v", line, err)
+	}
+	target := strings.TrimSuffix(strings.TrimSpace(line[strings.Index(line, "http"):]), ", use it with:")
+
+	c.mustRun("config", "new-context", "fake", "--cluster-target", target, "--sso-target", target)
+	c.expectExit(exitAuth, "login", "-u", "me@example.com", "-p", "wrong", "--context", "fake")
+	c.mustRun("login", "-u", "me@example.com", "-p", "secret", "--context", "fake")
+	c.mustRun("create", "environment", "org1:env1", "env1.example.com", "--context", "fake")
+	if stdout := c.mustRun("get", "environment", "org1:env1", "--context", "fake"); !strings.Contains(stdout, "env1.example.com") {
+		t.Errorf("get environment from the fake server printed %s", stdout)
+	}
+
+	c.expectExit(exitUsage, "dev", "fake-server", "--user", "nobody")
+}
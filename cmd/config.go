@@ -3,7 +3,8 @@ package cmd
 import (
   "fmt"
   "os"
-  "log"
+  "path/filepath"
+  "text/tabwriter"
 
   "github.com/spf13/cobra"
   "github.com/30x/shipyardctl/utils"
@@ -11,6 +12,17 @@ import (
 
 var cluster string
 var sso string
+var clientID string
+var clientSecret string
+var tlsSettings utils.Cluster
+var credentialStore string
+var keyFile string
+var setCluster string
+var setSSO string
+var setClientID string
+var setClientSecret string
+var setOrg string
+var setEnv string
 
 var useContextCmd = &cobra.Command{
 	Use:   "use-context",
@@ -21,25 +33,23 @@ You should login again after using this, to be sure the token is fresh.
 Example of use:
 
 $ shipyardctl config use-context e2e`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
     if len(args) < 1 {
-      fmt.Println("Missing required context name")
-      os.Exit(-1)
+      return usageError(cmd, "Missing required context name")
     }
 
     contextName := args[0]
 
-    if config == nil { // no config file
-      fmt.Println("There is no config file present at:", utils.GetConfigPath())
-    } else { // switch the current context to give name
-      err := config.SetContext(contextName)
-      if err != nil {
-        fmt.Println(err)
-        os.Exit(-1)
-      }
+    if err := requireConfigFile(); err != nil {
+      return err
+    }
+
+    err := config.SetContext(contextName)
+    if err != nil {
+      return err
     }
 
-    return
+    return nil
 	},
 }
 
@@ -53,27 +63,225 @@ and sso-target=https://login.apigee.com unless otherwise specified.
 Example of use:
 
 $ shipyardctl config new-context e2e`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
     if len(args) < 1 {
-      fmt.Println("Missing required context name")
-      os.Exit(-1)
+      return usageError(cmd, "Missing required context name")
     }
 
     contextName := args[0]
 
-    if config == nil { // no config file
-      fmt.Println("There is no config file present at:", utils.GetConfigPath())
-    } else { // switch the current context to give name
-      err := config.NewContext(contextName, sso, cluster)
-      if err != nil {
-        fmt.Println(err)
-        os.Exit(-1)
-      }
+    if err := requireConfigFile(); err != nil {
+      return err
+    }
+
+    info := tlsSettings
+    info.CertificateAuthority = absolutePath(info.CertificateAuthority)
+    info.ClientCertificate = absolutePath(info.ClientCertificate)
+    info.ClientKey = absolutePath(info.ClientKey)
+    info.Cluster, info.SSO, info.ClientID, info.ClientSecret = cluster, sso, clientID, clientSecret
+    err := config.NewContext(contextName, info)
+    if err != nil {
+      return err
     }
 
     fmt.Printf("New context %s added!\nPlease switch contexts and login.\n", contextName)
 
-    return
+    return nil
+	},
+}
+
+var getContextsCmd = &cobra.Command{
+	Use:   "get-contexts",
+	Short: "list contexts",
+	Long: `Lists the contexts in the config file, marking the current one with '*'.
+
+Example of use:
+
+$ shipyardctl config get-contexts`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+    fmt.Fprintln(w, "CURRENT\tNAME\tCLUSTER\tSSO\tUSER")
+    for _, con := range config.Contexts {
+      current := ""
+      if con.Name == config.CurrentContext {
+        current = "*"
+      }
+
+      fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, con.Name, con.ClusterInfo.Cluster,
+        con.ClusterInfo.SSO, con.UserInfo.Username)
+    }
+    return w.Flush()
+	},
+}
+
+var currentContextCmd = &cobra.Command{
+	Use:   "current-context",
+	Short: "print the current context",
+	Long: `Prints the name of the current context.
+
+Example of use:
+
+$ shipyardctl config current-context`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    fmt.Println(config.CurrentContext)
+    return nil
+	},
+}
+
+var deleteContextCmd = &cobra.Command{
+	Use:   "delete-context <name>",
+	Short: "delete a context",
+	Long: `Removes the context with the given name, along with its stored credentials.
+The current context cannot be deleted, switch to another one first.
+
+Example of use:
+
+$ shipyardctl config delete-context e2e`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    if len(args) < 1 {
+      return usageError(cmd, "Missing required context name")
+    }
+
+    if err := requireConfigFile(); err != nil {
+      return err
+    }
+
+    err := config.DeleteContext(args[0])
+    if err != nil {
+      return err
+    }
+
+    fmt.Printf("Deleted context %s\n", args[0])
+    return nil
+	},
+}
+
+var renameContextCmd = &cobra.Command{
+	Use:   "rename-context <name> <newName>",
+	Short: "rename a context",
+	Long: `Renames a context, keeping its cluster information and credentials.
+
+Example of use:
+
+$ shipyardctl config rename-context e2e staging`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    if len(args) < 2 {
+      return usageError(cmd, "Missing required context names")
+    }
+
+    if err := requireConfigFile(); err != nil {
+      return err
+    }
+
+    err := config.RenameContext(args[0], args[1])
+    if err != nil {
+      return err
+    }
+
+    fmt.Printf("Renamed context %s to %s\n", args[0], args[1])
+    return nil
+	},
+}
+
+var setContextCmd = &cobra.Command{
+	Use:   "set-context <name>",
+	Short: "edit a context",
+	Long: `Changes the cluster information or the defaults of an existing context. Only
+the values of the flags given are changed.
+
+With a default org, the --org flag and APIGEE_ORG can be left out. With a default
+environment, the environment name argument can be left out of environment and
+deployment commands. Either flag takes an empty value to remove the default.
+
+Example of use:
+
+$ shipyardctl config set-context e2e --cluster-target=https://e2e.shipyard.com
+
+$ shipyardctl config set-context e2e --sso-target=https://login.e2e.com --client-id=myclient --client-secret=mysecret
+
+$ shipyardctl config set-context e2e --org org1 --env env1
+$ shipyardctl get deployment dep1
+
+$ shipyardctl config set-context dev --certificate-authority=./dev-ca.pem`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    if len(args) < 1 {
+      return usageError(cmd, "Missing required context name")
+    }
+
+    if err := requireConfigFile(); err != nil {
+      return err
+    }
+
+    flags := cmd.Flags()
+    err := config.ModifyContext(args[0], func(con *utils.Context) {
+      if flags.Changed("cluster-target") {
+        con.ClusterInfo.Cluster = setCluster
+      }
+      if flags.Changed("sso-target") {
+        con.ClusterInfo.SSO = setSSO
+      }
+      if flags.Changed("client-id") {
+        con.ClusterInfo.ClientID = setClientID
+      }
+      if flags.Changed("org") {
+        con.Org = setOrg
+      }
+      if flags.Changed("env") {
+        con.Environment = setEnv
+      }
+      if flags.Changed("certificate-authority") {
+        con.ClusterInfo.CertificateAuthority = absolutePath(tlsSettings.CertificateAuthority)
+      }
+      if flags.Changed("client-certificate") {
+        con.ClusterInfo.ClientCertificate = absolutePath(tlsSettings.ClientCertificate)
+      }
+      if flags.Changed("client-key") {
+        con.ClusterInfo.ClientKey = absolutePath(tlsSettings.ClientKey)
+      }
+      if flags.Changed("insecure-skip-tls-verify") {
+        con.ClusterInfo.InsecureSkipTLSVerify = tlsSettings.InsecureSkipTLSVerify
+      }
+    })
+    if err != nil {
+      return err
+    }
+
+    if flags.Changed("client-secret") {
+      if err = config.SetClientSecret(args[0], setClientSecret); err != nil {
+        return err
+      }
+    }
+
+    fmt.Printf("Context %s updated\n", args[0])
+    return nil
+	},
+}
+
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check the config file for problems",
+	Long: `Checks the config file(s) for unknown keys, an outdated or unsupported
+apiVersion, malformed cluster and SSO URLs, and a current context that does not
+exist. Exits with a non-zero status when any problem is found.
+
+Example of use:
+
+$ shipyardctl config validate`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    problems, err := utils.ValidateConfig()
+    if err != nil {
+      return err
+    }
+
+    if len(problems) == 0 {
+      fmt.Println("Config is valid")
+      return nil
+    }
+
+    for _, problem := range problems {
+      fmt.Println(problem)
+    }
+    return fmt.Errorf("Found %d problem(s) in the config", len(problems))
 	},
 }
 
@@ -85,18 +293,42 @@ var viewConfigCmd = &cobra.Command{
 Example of use:
 
 $ shipyardctl config view`,
-	Run: func(cmd *cobra.Command, args []string) {
-
-    if config == nil { // no config file
-      fmt.Println("There is no config file present at:", utils.GetConfigPath())
-    } else { // dump config file to stdout
-      err := config.DumpConfig()
-      if err != nil {
-        log.Fatal(err)
-      }
+	RunE: func(cmd *cobra.Command, args []string) error {
+    return config.DumpConfig()
+	},
+}
+
+var migrateCredentialsCmd = &cobra.Command{
+	Use:   "migrate-credentials",
+	Short: "move stored tokens and client secrets to another credential store",
+	Long: `Moves the tokens and OAuth client secrets of every context to the given credential store:
+
+  keyring         the OS keyring (Secret Service via secret-tool on Linux, the Keychain on macOS)
+  encrypted-file  $HOME/.shipyardctl/credentials.enc, encrypted with the passphrase in
+                  SHIPYARDCTL_PASSPHRASE (prompted for otherwise) or the key in --key-file
+  plain           the config file itself, readable by you only
+
+Example of use:
+
+$ shipyardctl config migrate-credentials --to keyring
+
+$ shipyardctl config migrate-credentials --to encrypted-file --key-file ~/.shipyardctl/key`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    if err := requireConfigFile(); err != nil {
+      return err
+    }
+
+    if credentialStore == "" {
+      return usageError(cmd, "Missing required flag '--to'")
     }
 
-    return
+    err := config.MigrateCredentials(credentialStore, keyFile)
+    if err != nil {
+      return err
+    }
+
+    fmt.Printf("Credentials are now kept in the %s store\n", credentialStore)
+    return nil
 	},
 }
 
@@ -111,7 +343,9 @@ $ shipyardctl config use-context e2e
 
 $ shipyardctl config view
 
-$ shipyardctl config new-context prod --cluster-target=https://my.shipyard.com`,
+$ shipyardctl config new-context prod --cluster-target=https://my.shipyard.com
+
+$ shipyardctl config get-contexts`,
 }
 
 func init() {
@@ -120,5 +354,50 @@ func init() {
   ConfigCmd.AddCommand(newContextCmd)
   newContextCmd.Flags().StringVarP(&cluster, "cluster-target", "c", "https://shipyard.apigee.com", "Indicates the URL of the target cluster")
   newContextCmd.Flags().StringVarP(&sso, "sso-target", "s", "https://login.apigee.com", "Indicates the URL of the SSO target")
+  newContextCmd.Flags().StringVar(&clientID, "client-id", "", "OAuth client used to login at the SSO target, defaults to edgecli")
+  newContextCmd.Flags().StringVar(&clientSecret, "client-secret", "", "Secret of the OAuth client used to login")
+  addTLSFlags(newContextCmd)
+  ConfigCmd.AddCommand(validateConfigCmd)
+  withoutConfig[validateConfigCmd] = true
+  ConfigCmd.AddCommand(getContextsCmd)
+  ConfigCmd.AddCommand(currentContextCmd)
+  ConfigCmd.AddCommand(deleteContextCmd)
+  ConfigCmd.AddCommand(renameContextCmd)
+  ConfigCmd.AddCommand(setContextCmd)
+  setContextCmd.Flags().StringVarP(&setCluster, "cluster-target", "c", "", "URL of the target cluster")
+  setContextCmd.Flags().StringVarP(&setSSO, "sso-target", "s", "", "URL of the SSO target")
+  setContextCmd.Flags().StringVar(&setClientID, "client-id", "", "OAuth client used to login at the SSO target")
+  setContextCmd.Flags().StringVar(&setClientSecret, "client-secret", "", "Secret of the OAuth client used to login")
+  setContextCmd.Flags().StringVar(&setOrg, "org", "", "Default Apigee org")
+  setContextCmd.Flags().StringVar(&setEnv, "env", "", "Default environment name, e.g. env1 or org1:env1")
+  addTLSFlags(setContextCmd)
+  ConfigCmd.AddCommand(migrateCredentialsCmd)
+  migrateCredentialsCmd.Flags().StringVar(&credentialStore, "to", "", "Credential store to move to: keyring, encrypted-file or plain")
+  migrateCredentialsCmd.Flags().StringVar(&keyFile, "key-file", "", "Key file to encrypt the encrypted-file store with, instead of a passphrase")
+  for _, sub := range ConfigCmd.Commands() {
+    withoutCurrentContext[sub] = true
+  }
   RootCmd.AddCommand(ConfigCmd)
-}
\ No newline at end of file
+}
+
+// addTLSFlags adds the flags setting how the cluster and SSO targets of a context are reached over TLS
+func addTLSFlags(cmd *cobra.Command) {
+  cmd.Flags().StringVar(&tlsSettings.CertificateAuthority, "certificate-authority", "", "PEM file of the CA(s) to trust, on top of the system ones")
+  cmd.Flags().StringVar(&tlsSettings.ClientCertificate, "client-certificate", "", "PEM file of the client certificate to present")
+  cmd.Flags().StringVar(&tlsSettings.ClientKey, "client-key", "", "PEM file of the key of the client certificate")
+  cmd.Flags().BoolVar(&tlsSettings.InsecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Do not verify server certificates, e.g. of a dev cluster. Insecure!")
+}
+
+// absolutePath so that files given relative to the working directory are found from anywhere
+func absolutePath(path string) string {
+  if path == "" {
+    return ""
+  }
+
+  abs, err := filepath.Abs(path)
+  if err != nil {
+    return path
+  }
+
+  return abs
+}
@@ -0,0 +1,308 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/30x/shipyardctl/utils"
+)
+
+var printCurl bool
+
+// shell variables standing in for the secrets of a login, by form field or
+// query parameter name
+var curlVariables = map[string]string{
+	"password": "APIGEE_PASSWORD",
+	"mfa_token": "APIGEE_MFA",
+	"client_secret": "APIGEE_CLIENT_SECRET",
+	"refresh_token": "APIGEE_REFRESH_TOKEN",
+}
+
+// a secret in a curl command is replaced by a shell variable, marked within
+// the arguments by these private use runes until they are quoted
+const (
+	variableStart = "\uE000"
+	variableEnd = "\uE001"
+)
+
+// printCurlCommand prints the request as a curl command to stderr, when asked to
+// with --print-curl or -v=8
+func printCurlCommand(req *http.Request, body []byte) {
+	if printCurl || logging(logCurl) {
+		fmt.Fprintln(logOutput, curlCommand(req, body))
+	}
+}
+
+// curlCommand a curl command sending the same request, with the secrets in it replaced
+// by shell variables, e.g. the bearer token by $APIGEE_TOKEN
+func curlCommand(req *http.Request, body []byte) string {
+	args := []string{"curl", "-X", req.Method, shellQuote(curlURL(req.URL))}
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	isMultipart := mediaType == "multipart/form-data"
+
+	names := []string{}
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "Content-Length" || name == "Content-Type" && isMultipart { // set by curl
+			continue
+		}
+
+		for _, value := range req.Header[name] {
+			args = append(args, curlHeader(name, value)...)
+		}
+	}
+
+	if len(body) > 0 {
+		switch {
+		case isMultipart:
+			args = append(args, curlForm(body, params["boundary"])...)
+		case mediaType == "application/x-www-form-urlencoded":
+			args = append(args, curlURLEncoded(body)...)
+		case strings.Contains(mediaType, "json"):
+			args = append(args, "-d", shellQuote(curlJSON(body)))
+		default:
+			args = append(args, "--data-binary", shellQuote(string(body)))
+		}
+	}
+
+	return strings.Join(args, " ")
+}
+
+// variable the marker of the shell variable of the given name
+func variable(name string) string {
+	return variableStart + name + variableEnd
+}
+
+// loginVariableFor the shell variable standing in for a secret of a login, e.g.
+// APIGEE_PASSWORD for its password, or as for any other field when unknown
+func loginVariableFor(name string) string {
+	if known, ok := curlVariables[strings.ToLower(name)]; ok {
+		return variable(known)
+	}
+
+	return variableFor(name)
+}
+
+// variableFor the shell variable standing in for a secret field, named after the
+// field itself, e.g. DB_PASSWORD for an environment variable of that name
+func variableFor(name string) string {
+	return variable(strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+
+		return '_'
+	}, strings.ToUpper(name)))
+}
+
+// curlURL the URL with secret query parameters replaced, e.g. the mfa_token of a login
+func curlURL(u *url.URL) string {
+	query := u.Query()
+	markers := map[string]string{}
+	for name := range query {
+		if isSecret(name) {
+			query.Set(name, loginVariableFor(name))
+			markers[url.QueryEscape(loginVariableFor(name))] = loginVariableFor(name)
+		}
+	}
+
+	if len(markers) == 0 {
+		return u.String()
+	}
+
+	copied := *u
+	copied.RawQuery = query.Encode()
+	text := copied.String()
+	for escaped, marker := range markers {
+		text = strings.Replace(text, escaped, marker, -1)
+	}
+
+	return text
+}
+
+// curlHeader the curl arguments setting the header, with the bearer token as $APIGEE_TOKEN
+// and the secret of any OAuth client other than the default one as $APIGEE_CLIENT_SECRET
+func curlHeader(name string, value string) []string {
+	if strings.EqualFold(name, "Authorization") {
+		scheme := strings.SplitN(value, " ", 2)
+		switch {
+		case len(scheme) == 2 && strings.EqualFold(scheme[0], "Bearer"):
+			value = "Bearer " + variable("APIGEE_TOKEN")
+		case len(scheme) == 2 && strings.EqualFold(scheme[0], "Basic"):
+			if credentials, err := base64.StdEncoding.DecodeString(scheme[1]); err == nil {
+				client := strings.SplitN(string(credentials), ":", 2)
+				if len(client) == 2 && client[0] == utils.DefaultClientID && client[1] == utils.DefaultClientSecret {
+					return []string{"-u", shellQuote(string(credentials))}
+				}
+
+				return []string{"-u", shellQuote(client[0] + ":" + variable("APIGEE_CLIENT_SECRET"))}
+			}
+
+			value = "Basic " + variable("APIGEE_CLIENT_CREDENTIALS")
+		default:
+			value = variable("APIGEE_AUTHORIZATION")
+		}
+	} else if isSecret(name) {
+		value = variableFor(name)
+	}
+
+	return []string{"-H", shellQuote(name + ": " + value)}
+}
+
+// curlForm the -F arguments of a multipart form, e.g. the zipped app and fields of
+// an image build. Files are referred to by name, relative to the working directory.
+func curlForm(body []byte, boundary string) []string {
+	args := []string{}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return args
+		} else if err != nil {
+			return append(args, "--data-binary", shellQuote(string(body)))
+		}
+
+		if part.FileName() != "" {
+			args = append(args, "-F", shellQuote(part.FormName() + "=@" + part.FileName()))
+			continue
+		}
+
+		content, _ := ioutil.ReadAll(part)
+		value := string(content)
+		if kv := strings.SplitN(value, "=", 2); isSecret(part.FormName()) {
+			value = variableFor(part.FormName())
+		} else if len(kv) == 2 && isSecret(kv[0]) {
+			value = kv[0] + "=" + variableFor(kv[0])
+		}
+
+		args = append(args, "--form-string", shellQuote(part.FormName() + "=" + value))
+	}
+}
+
+// curlURLEncoded the --data-urlencode arguments of a form, e.g. of a login
+func curlURLEncoded(body []byte) []string {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return []string{"-d", shellQuote(string(body))}
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			if isSecret(name) {
+				value = loginVariableFor(name)
+			}
+
+			args = append(args, "--data-urlencode", shellQuote(name + "=" + value))
+		}
+	}
+
+	return args
+}
+
+// curlJSON the JSON body with the values of secret fields, such as those of
+// secret environment variables, replaced by shell variables
+func curlJSON(body []byte) string {
+	var value interface{}
+	if json.Unmarshal(body, &value) != nil {
+		return string(body)
+	}
+
+	encoded, err := json.Marshal(replaceSecrets(value))
+	if err != nil {
+		return string(body)
+	}
+
+	return string(encoded)
+}
+
+// replaceSecrets like redactJSON, replacing secrets by shell variables instead
+func replaceSecrets(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		secretName := ""
+		for key, field := range value {
+			if name, ok := field.(string); ok && strings.EqualFold(key, "name") && isSecret(name) {
+				secretName = name
+			}
+		}
+
+		for key, field := range value {
+			if _, isString := field.(string); isString && secretName != "" && strings.EqualFold(key, "value") {
+				value[key] = variableFor(secretName)
+			} else if isString && isSecret(key) {
+				value[key] = variableFor(key)
+			} else {
+				value[key] = replaceSecrets(field)
+			}
+		}
+	case []interface{}:
+		for ndx, item := range value {
+			value[ndx] = replaceSecrets(item)
+		}
+	}
+
+	return value
+}
+
+// shellQuote quotes the argument for a POSIX shell, expanding the shell
+// variables marked in it, e.g. 'Authorization: Bearer '"$APIGEE_TOKEN"
+func shellQuote(arg string) string {
+	quoted := ""
+	for _, piece := range strings.Split(arg, variableStart) {
+		literal := piece
+		if end := strings.Index(piece, variableEnd); end >= 0 {
+			quoted += `"$` + piece[:end] + `"`
+			literal = piece[end + len(variableEnd):]
+		}
+
+		if literal != "" {
+			quoted += "'" + strings.Replace(literal, "'", `'\''`, -1) + "'"
+		}
+	}
+
+	if quoted == "" {
+		return "''"
+	}
+
+	return quoted
+}
+
+func init() {
+	RootCmd.PersistentFlags().BoolVar(&printCurl, "print-curl", false, "Print a curl command to stderr for every API call, with the token as $APIGEE_TOKEN")
+}
@@ -0,0 +1,107 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"":                                   "''",
+		"plain":                              "'plain'",
+		"it's":                               `'it'\''s'`,
+		"Bearer " + variable("APIGEE_TOKEN"): `'Bearer '"$APIGEE_TOKEN"`,
+		variable("PASSWORD"):                 `"$PASSWORD"`,
+		"a" + variable("X") + "b'c":          `'a'"$X"'b'\''c'`,
+	}
+
+	for arg, expected := range cases {
+		if got := shellQuote(arg); got != expected {
+			t.Errorf("shellQuote(%q) gave %s, expected %s", arg, got, expected)
+		}
+	}
+}
+
+func TestCurlCommandReplacesSecrets(t *testing.T) {
+	body := []byte(`{"DeploymentName":"dep1","EnvVars":[{"Name":"PASSWORD","Value":"abc"}]}`)
+	req, _ := http.NewRequest("POST", "https://shipyard.example.com/environments/org1:env1/deployments", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Content-Type", "application/json")
+
+	command := curlCommand(req, body)
+	expected := `curl -X POST 'https://shipyard.example.com/environments/org1:env1/deployments' -H 'Authorization: Bearer '"$APIGEE_TOKEN" ` +
+		`-H 'Content-Type: application/json' -d '{"DeploymentName":"dep1","EnvVars":[{"Name":"PASSWORD","Value":"'"$PASSWORD"'"}]}'`
+	if command != expected {
+		t.Errorf("curlCommand gave\n%s\nexpected\n%s", command, expected)
+	}
+}
+
+func TestCurlCommandOfLogin(t *testing.T) {
+	form := url.Values{}
+	form.Set("username", "me@example.com")
+	form.Set("password", "pw")
+	form.Set("grant_type", "password")
+	body := []byte(form.Encode())
+
+	req, _ := http.NewRequest("POST", "https://login.example.com/oauth/token?mfa_token=123456", bytes.NewReader(body))
+	req.SetBasicAuth("edgecli", "edgeclisecret")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	command := curlCommand(req, body)
+	for _, part := range []string{
+		`'https://login.example.com/oauth/token?mfa_token='"$APIGEE_MFA"`,
+		`-u 'edgecli:edgeclisecret'`,
+		`--data-urlencode 'password='"$APIGEE_PASSWORD"`,
+		`--data-urlencode 'username=me@example.com'`,
+	} {
+		if !strings.Contains(command, part) {
+			t.Errorf("curlCommand of a login lacks %s:\n%s", part, command)
+		}
+	}
+
+	if strings.Contains(command, "123456") || strings.Contains(command, "=pw") {
+		t.Errorf("curlCommand of a login shows its secrets:\n%s", command)
+	}
+}
+
+func TestCurlCommandOfImageBuild(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	file, _ := writer.CreateFormFile("file", "app.zip")
+	file.Write([]byte("zip"))
+	writer.WriteField("name", "example")
+	writer.WriteField("envVar", "API_KEY=abc")
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "https://shipyard.example.com/imagespaces/org1/images", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	command := curlCommand(req, body.Bytes())
+	for _, part := range []string{`-F 'file=@app.zip'`, `--form-string 'name=example'`, `--form-string 'envVar=API_KEY='"$API_KEY"`} {
+		if !strings.Contains(command, part) {
+			t.Errorf("curlCommand of an image build lacks %s:\n%s", part, command)
+		}
+	}
+
+	if strings.Contains(command, "Content-Type") {
+		t.Errorf("curlCommand of a multipart form sets its own Content-Type:\n%s", command)
+	}
+}
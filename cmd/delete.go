@@ -15,10 +15,17 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
+var cascade bool
+var assumeYes bool
+
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
 	Use:   "delete [command]",
@@ -30,3 +37,43 @@ based on the given meta-data.`,
 func init() {
 	RootCmd.AddCommand(deleteCmd)
 }
+
+// confirmDeletion describes what is about to be removed and requires the
+// environment name to be typed back before continuing, unless --yes or --dry-run was given
+func confirmDeletion(envName string, targets []string) error {
+	if dryRun {
+		printDryRun(targets)
+		return nil
+	}
+
+	if assumeYes {
+		return nil
+	}
+
+	fmt.Println("The following will be permanently deleted:")
+	for _, target := range targets {
+		fmt.Println("\t" + target)
+	}
+
+	consolereader := bufio.NewReader(os.Stdin)
+	fmt.Printf("Type the environment name '%s' to confirm:\n", envName)
+
+	input, err := consolereader.ReadString('\n')
+	if err != nil {
+		return newError(exitError, "Unable to read confirmation. Use --yes to skip it.")
+	}
+
+	if strings.TrimSpace(input) != envName {
+		return newError(exitError, "Confirmation did not match. Nothing was deleted.")
+	}
+
+	return nil
+}
+
+// printDryRun lists what a deletion would have removed
+func printDryRun(targets []string) {
+	fmt.Println("Dry run, nothing will be deleted. Would delete:")
+	for _, target := range targets {
+		fmt.Println("\t" + target)
+	}
+}
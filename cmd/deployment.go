@@ -18,8 +18,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"io"
-	"log"
 	"encoding/json"
 	"bytes"
 	"strconv"
@@ -43,10 +41,11 @@ type Deployment struct {
 }
 
 type DeploymentPatch struct {
-	PublicHosts string
-	PrivateHosts string
-	Replicas int64
-	PtsUrl string
+	PublicHosts string `json:",omitempty"`
+	PrivateHosts string `json:",omitempty"`
+	Replicas int64 `json:",omitempty"`
+	PtsUrl string `json:",omitempty"`
+	EnvVars []EnvVar `json:",omitempty"`
 }
 
 const (
@@ -65,183 +64,205 @@ available information in JSON format.
 
 Example of use:
 $ shipyardctl get deployment dep1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
 
 		// get all of the active deployments
 		if all {
-			status := getDeploymentAll(envName)
-			if !CheckIfAuthn(status) {
-				// retry once more
-				status := getDeploymentAll(envName)
-				if status == 401 {
-					fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-					fmt.Println("Command failed.")
-				}
-			}
-		} else { // get active deployment by name
-			if len(args) < 2 {
-				fmt.Println("Missing required arg <deplymentName>\n")
-				fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-				return
-			}
+			status, err := retryIfUnauthorized(func() (int, error) {
+				return getDeploymentAll(envName)
+			})
 
-			// get deployment name from arguments
-			depName = args[1]
-
-			status := getDeploymentNamed(envName, depName)
-			if !CheckIfAuthn(status) {
-				// retry once more
-				status := getDeploymentNamed(envName, depName)
-				if status == 401 {
-					fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-					fmt.Println("Command failed.")
-				}
-			}
+			return checkStatus(status, err, "Failed to retrieve the deployments of %s", envName)
+		}
+
+		// get active deployment by name
+		if len(args) < 2 {
+			return usageError(cmd, "Missing required arg <deplymentName>")
 		}
+
+		// get deployment name from arguments
+		depName = args[1]
+
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return getDeploymentNamed(envName, depName)
+		})
+
+		return resourceError(status, err, "retrieve", "deployment", depName, envName)
 	},
 }
 
-func getDeploymentNamed(envName string, depName string) int {
+func getDeploymentNamed(envName string, depName string) (int, error) {
 	// build API call
 	req, err := http.NewRequest("GET", clusterTarget + enroberPath + "/" + envName + "/deployments/" + depName, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	// dump response body to stdout
 	defer response.Body.Close()
+	return response.StatusCode, printResponse(response)
+}
 
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+// fetchDeployment retrieves and decodes the named deployment
+func fetchDeployment(envName string, depName string) (*Deployment, int, error) {
+	body, status, err := fetchResource(enroberPath + "/" + envName + "/deployments/" + depName)
+	if err != nil || status != 200 {
+		return nil, status, err
 	}
 
-	return response.StatusCode
-}
+	deployment := &Deployment{}
+	err = json.Unmarshal(body, deployment)
+	if err != nil {
+		return nil, status, fmt.Errorf("Unable to read deployment %s: %v", depName, err)
+	}
 
-func getDeploymentAll(envName string) int {
-	req, err := http.NewRequest("GET", clusterTarget + enroberPath + "/" + envName + "/deployments" , nil)
-		if verbose {
-			PrintVerboseRequest(req)
-		}
+	return deployment, status, nil
+}
 
-		req.Header.Set("Authorization", "Bearer " + authToken)
-		response, err := http.DefaultClient.Do(req)
+// listDeployments retrieves and decodes all active deployments in the given environment
+func listDeployments(envName string) ([]Deployment, int, error) {
+	body, status, err := fetchResource(enroberPath + "/" + envName + "/deployments")
+	if err != nil || status != 200 {
+		return nil, status, err
+	}
 
-		if err != nil {
-			log.Fatal(err)
-		}
+	deployments := []Deployment{}
+	err = json.Unmarshal(body, &deployments)
+	if err != nil {
+		return nil, status, fmt.Errorf("Unable to read the deployments of %s: %v", envName, err)
+	}
 
-		if verbose {
-			PrintVerboseResponse(response)
-		}
+	return deployments, status, nil
+}
 
-		defer response.Body.Close()
+func getDeploymentAll(envName string) (int, error) {
+	req, err := http.NewRequest("GET", clusterTarget + enroberPath + "/" + envName + "/deployments" , nil)
+	if err != nil {
+		return 0, err
+	}
 
-		if response.StatusCode != 401 {
-			_, err = io.Copy(os.Stdout, response.Body)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
+	}
 
-		return response.StatusCode
+	defer response.Body.Close()
+	return response.StatusCode, printResponse(response)
 }
 
 var deleteDeploymentCmd = &cobra.Command{
 	Use:   "deployment <environmentName> <deploymentName>",
 	Short: "deletes an active deployment",
 	Long: `Given the name of an active deployment and the environment it belongs to,
-this will delete it.
+this will delete it. With --all, every deployment in the environment is deleted.
+You will be asked to type the environment name to confirm, unless --yes is given.
 
 Example of use:
-$ shipyardctl delete deployment org1:env1 dep1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+$ shipyardctl delete deployment org1:env1 dep1 --token <token>
+
+$ shipyardctl delete deployment org1:env1 --all --dry-run`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		// check and pull required arguments
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
 
-		if len(args) < 2 {
-			fmt.Println("Missing required arg <deplymentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+		var names []string
+		if all {
+			var deployments []Deployment
+			status, err := retryIfUnauthorized(func() (status int, err error) {
+				deployments, status, err = listDeployments(envName)
+				return
+			})
+
+			if err = checkStatus(status, err, "Unable to retrieve the deployments of %s. Nothing was deleted", envName); err != nil {
+				return err
+			}
+
+			for _, dep := range deployments {
+				names = append(names, dep.DeploymentName)
+			}
+
+			if len(names) == 0 {
+				fmt.Println("There are no deployments in " + envName + ".")
+				return nil
+			}
+		} else {
+			if len(args) < 2 {
+				return usageError(cmd, "Missing required arg <deplymentName>")
+			}
+
+			names = []string{args[1]}
 		}
 
-		depName = args[1]
+		targets := []string{}
+		for _, name := range names {
+			targets = append(targets, "deployment " + name + " in " + envName)
+		}
 
-		status := deleteDeployment(envName, depName)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := deleteDeployment(envName, depName)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
+		if err := confirmDeletion(envName, targets); err != nil {
+			return err
+		}
+
+		var failure error
+		for _, name := range names {
+			depName = name
+			status, err := retryIfUnauthorized(func() (int, error) {
+				return deleteDeployment(envName, depName)
+			})
+
+			if err = resourceError(status, err, "delete", "deployment", depName, envName); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failure = err
 			}
 		}
+
+		return failure
 	},
 }
 
-func deleteDeployment(envName string, depName string) int {
+func deleteDeployment(envName string, depName string) (int, error) {
 	// build API call URL
 	req, err := http.NewRequest("DELETE", clusterTarget + enroberPath + "/" + envName + "/deployments/" + depName, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	// dump response body to stdout
 	defer response.Body.Close()
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		fmt.Println("\nDeletion of " + depName + " in " + envName + " was successful\n")
-	}
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = printResponse(response)
+	if err == nil && isSuccess(response.StatusCode) {
+		printSuccess("Deletion of %s in %s was successful", depName, envName)
 	}
 
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 // deployment creation command
@@ -253,15 +274,19 @@ of replicas and the URL that locates the appropriate Pod Template Spec built by
 It also requires an active environment to deploy to.
 
 Example of use:
-$ shipyardctl create deployment org1:env1 dep1 "test.host.name" "test.host.name" 2 "https://pts.url.com" --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+$ shipyardctl create deployment org1:env1 dep1 "test.host.name" "test.host.name" 2 "https://pts.url.com" --token <token>
+
+With --smoke, the deployment's public hosts are tested once it is created, as
+with 'shipyardctl test deployment', and the command fails if it is unhealthy.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		// check and pull required args
 		if len(args) < 6 {
-			fmt.Println("Missing required args\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required args")
 		}
 
 		envName = args[0]
@@ -270,63 +295,57 @@ $ shipyardctl create deployment org1:env1 dep1 "test.host.name" "test.host.name"
 		privateHost := args[3]
 		replicas, err := strconv.ParseInt(args[4], 0, 64)
 		if err != nil {
-			log.Fatal(err)
+			return usageError(cmd, "Invalid number of replicas: %s", args[4])
 		}
 		ptsUrl := args[5]
-		vars := parseEnvVars()
-
-		status := createDeployment(envName, depName, publicHost, privateHost, replicas, ptsUrl, vars)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := createDeployment(envName, depName, publicHost, privateHost, replicas, ptsUrl, vars)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
+		vars, err := parseEnvVars()
+		if err != nil {
+			return usageError(cmd, "%v", err)
 		}
+
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return createDeployment(envName, depName, publicHost, privateHost, replicas, ptsUrl, vars)
+		})
+
+		if err = resourceError(status, err, "create", "deployment", depName, envName); err != nil {
+			return err
+		}
+
+		if smoke && !dryRun {
+			return runSmokeTest(envName, depName)
+		}
+
+		return nil
 	},
 }
 
-func createDeployment(envName string, depName string, publicHost string, privateHost string, replicas int64, ptsUrl string, vars []EnvVar) int {
+func createDeployment(envName string, depName string, publicHost string, privateHost string, replicas int64, ptsUrl string, vars []EnvVar) (int, error) {
 	// prepare arguments in a Deployment struct and Marshal into JSON
 	js, err := json.Marshal(Deployment{depName, publicHost, privateHost, replicas, ptsUrl, vars})
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	// build API call with request body (deployment information)
 	req, err := http.NewRequest("POST", clusterTarget + enroberPath + "/" + envName + "/deployments", bytes.NewBuffer(js))
-
-	if verbose {
-		PrintVerboseRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
-	req.Header.Set("Authorization", "Bearer " + authToken)
 	req.Header.Set("Content-Type", "application/json")
-	response, err := http.DefaultClient.Do(req)
-
+	response, err := doRequest(req)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	if verbose {
-		PrintVerboseResponse(response)
+		return 0, err
 	}
 
 	// dump response to stdout
 	defer response.Body.Close()
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		fmt.Println("\nCreation of " + depName + " in " + envName + " was successful\n")
+	err = printResponse(response)
+	if err == nil && isSuccess(response.StatusCode) {
+		printSuccess("Creation of %s in %s was successful", depName, envName)
 	}
 
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 // patch/update deployment command
@@ -338,67 +357,62 @@ with the corresponding mutations. All properties, except for the deployment name
 That includes, the public or private hosts, replicas, PTS URL entirely, or the PTS itself.
 
 Example of use:
-$ shipyardctl patch deployment org1:env1 dep1 '{"replicas": 3, "publicHosts": "test.host.name.patch"}' --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+$ shipyardctl patch deployment org1:env1 dep1 '{"replicas": 3, "publicHosts": "test.host.name.patch"}' --token <token>
+
+With --smoke, the deployment's public hosts are tested once it is patched, as
+with 'shipyardctl test deployment', and the command fails if it is unhealthy.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		// check and pull required args
 		if len(args) < 3 {
-			fmt.Println("Missing required args\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required args")
 		}
 
 		envName = args[0]
 		depName = args[1]
 		updateData := args[2]
 
-		status := patchDeployment(envName, depName, updateData)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := patchDeployment(envName, depName, updateData)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return patchDeployment(envName, depName, updateData)
+		})
+
+		if err = resourceError(status, err, "patch", "deployment", depName, envName); err != nil {
+			return err
+		}
+
+		if smoke && !dryRun {
+			return runSmokeTest(envName, depName)
 		}
+
+		return nil
 	},
 }
 
-func patchDeployment(envName string, depName string, updateData string) int {
+func patchDeployment(envName string, depName string, updateData string) (int, error) {
 	// build API call
 	// the update data will come in from command line as a JSON string
 	req, err := http.NewRequest("PATCH", clusterTarget + enroberPath + "/" + envName + "/deployments/"+depName, bytes.NewBuffer([]byte(updateData)))
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	if verbose {
-		PrintVerboseRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	response, err := http.DefaultClient.Do(req)
-
+	response, err := doRequest(req)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	if verbose {
-		PrintVerboseResponse(response)
+		return 0, err
 	}
 
 	defer response.Body.Close()
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		fmt.Println("\nPatch of " + depName + " in " + envName + " was successful\n")
-	}
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = printResponse(response)
+	if err == nil && isSuccess(response.StatusCode) {
+		printSuccess("Patch of %s in %s was successful", depName, envName)
 	}
 
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 var logsCmd = &cobra.Command{
@@ -409,39 +423,34 @@ available logs.
 
 Example of use:
 $ shipyardctl get logs org1:env1 dep1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
 
 		if len(args) < 2 {
-			fmt.Println("Missing required arg <deplymentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <deplymentName>")
 		}
 
 		// get deployment name from arguments
 		depName = args[1]
 
-		status := getDeploymentLogs(envName, depName)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := getDeploymentLogs(envName, depName)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
-		}
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return getDeploymentLogs(envName, depName)
+		})
+
+		return resourceError(status, err, "retrieve the logs of", "deployment", depName, envName)
 	},
 }
 
-func getDeploymentLogs(envName string, depName string) int {
+func getDeploymentLogs(envName string, depName string) (int, error) {
 	var req *http.Request
 	var err error
 	// build API call
@@ -450,32 +459,18 @@ func getDeploymentLogs(envName string, depName string) int {
 	} else {
 		req, err = http.NewRequest("GET", clusterTarget + enroberPath + "/" + envName + "/deployments/" + depName + "/logs", nil)
 	}
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	// dump response body to stdout
 	defer response.Body.Close()
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, printResponse(response)
 }
 
 func init() {
@@ -486,23 +481,32 @@ func init() {
 	logsCmd.Flags().BoolVarP(&previous, "previous", "p", false, "used to retrieve previous container's logs")
 
 	deleteCmd.AddCommand(deleteDeploymentCmd)
+	deleteDeploymentCmd.Flags().BoolVarP(&all, "all", "a", false, "Delete all deployments in the environment")
+	deleteDeploymentCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Skip the interactive confirmation")
 	createCmd.AddCommand(createDeploymentCmd)
 	createDeploymentCmd.Flags().StringSliceVarP(&envVars, "env", "e", []string{}, "Environment variables to set in the deployment")
+	createDeploymentCmd.Flags().BoolVar(&smoke, "smoke", false, "Test the deployment's public hosts once it is created")
+	addSmokeTestFlags(createDeploymentCmd)
 	patchCmd.AddCommand(patchDeploymentCmd)
+	patchDeploymentCmd.Flags().BoolVar(&smoke, "smoke", false, "Test the deployment's public hosts once it is patched")
+	addSmokeTestFlags(patchDeploymentCmd)
+	withDryRun[createDeploymentCmd] = true
+	withDryRun[patchDeploymentCmd] = true
+	withDryRun[deleteDeploymentCmd] = true
 }
 
-func parseEnvVars() (parsed []EnvVar) {
-	var temp string
-
-	if len(envVars) > 0 {
-		for i := range envVars {
-			temp = envVars[i]
-			split := strings.Split(temp, "=")
-			parsed = append(parsed, EnvVar{split[NAME], split[VALUE]})
+// parseEnvVars reads the environment variables given with --env as "name=value",
+// the value keeping any further "="
+func parseEnvVars() ([]EnvVar, error) {
+	parsed := []EnvVar{}
+	for _, env := range envVars {
+		split := strings.SplitN(env, "=", 2)
+		if len(split) != 2 || split[NAME] == "" {
+			return nil, fmt.Errorf("Invalid environment variable %q, expected \"name=value\"", env)
 		}
-	} else {
-		return []EnvVar{}
+
+		parsed = append(parsed, EnvVar{split[NAME], split[VALUE]})
 	}
 
-	return parsed
+	return parsed, nil
 }
@@ -0,0 +1,107 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/30x/shipyardctl/fake"
+	"github.com/spf13/cobra"
+)
+
+var fakePort int
+var fakeUsers []string
+
+// devCmd represents the dev command
+var devCmd = &cobra.Command{
+	Use:   "dev [command]",
+	Short: "tools for developing against Shipyard",
+	Long: `This command, when paired with a subcommand, helps developing shipyardctl
+or apps for Shipyard without a cluster. An example call would look like:
+
+$ shipyardctl dev fake-server --port 8080`,
+}
+
+var fakeServerCmd = &cobra.Command{
+	Use:   "fake-server",
+	Short: "runs an in-memory Shipyard",
+	Long: `Runs a fake Shipyard on localhost until interrupted: the build API, the
+environments and deployments API and the SSO token endpoint, keeping their state
+in memory. Every shipyardctl command can be run against it by pointing a context
+at it. Any username and password can login, unless users are given with --user.
+
+Example of use:
+
+$ shipyardctl dev fake-server --port 8080 --user me@example.com:secret
+$ shipyardctl config new-context fake --cluster-target=http://localhost:8080 --sso-target=http://localhost:8080
+$ shipyardctl login --context fake -u me@example.com -p secret`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		server := fake.NewServer()
+		for _, user := range fakeUsers {
+			credentials := strings.SplitN(user, ":", 2)
+			if len(credentials) != 2 || credentials[0] == "" {
+				return usageError(cmd, "Users must be given as username:password")
+			}
+
+			server.Users[credentials[0]] = credentials[1]
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:" + strconv.Itoa(fakePort))
+		if err != nil {
+			return newError(exitError, "Unable to start the fake server: %v", err)
+		}
+
+		target := "http://localhost:" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		fmt.Fprintf(os.Stderr, "Fake Shipyard listening on %s, use it with:\n", target)
+		fmt.Fprintf(os.Stderr, "  shipyardctl config new-context fake --cluster-target=%s --sso-target=%s\n", target, target)
+
+		return http.Serve(listener, logRequests(server))
+	},
+}
+
+// logRequests logs the requests served at level 2, like the API calls of other commands
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		logV(logCalls, "served", "method", r.Method, "url", redactURL(r.URL), "status", recorder.status, "duration", time.Since(start) / time.Millisecond * time.Millisecond)
+	})
+}
+
+// statusRecorder remembers the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func init() {
+	RootCmd.AddCommand(devCmd)
+	devCmd.AddCommand(fakeServerCmd)
+	withoutConfig[fakeServerCmd] = true
+	fakeServerCmd.Flags().IntVar(&fakePort, "port", 8080, "Port to listen on, 0 for any free port")
+	fakeServerCmd.Flags().StringSliceVar(&fakeUsers, "user", []string{}, "User allowed to login, as username:password")
+}
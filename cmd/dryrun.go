@@ -0,0 +1,121 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+
+// commands that honor --dry-run, any other rejects it
+var withDryRun = map[*cobra.Command]bool{}
+
+// marks the responses made up for the requests left out by --dry-run
+const dryRunHeader = "X-Shipyardctl-Dry-Run"
+
+// requireDryRunSupport rejects --dry-run for commands that would not honor it,
+// e.g. those that wait for the changes they make
+func requireDryRunSupport(cmd *cobra.Command) error {
+	if !dryRun || withDryRun[cmd] {
+		return nil
+	}
+
+	return usageError(cmd, "--dry-run is not supported by %s", cmd.CommandPath())
+}
+
+// leftOut reports whether --dry-run leaves out the request: any request making
+// a change, except refreshing an expired token while resolving auth
+func leftOut(req *http.Request, body []byte) bool {
+	if !dryRun {
+		return false
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+
+	form, err := url.ParseQuery(string(body))
+	return err != nil || form.Get("grant_type") != "refresh_token"
+}
+
+// dryRunResponse prints the request that would have been sent to stdout, without
+// credentials, and makes up an empty successful response to it
+func dryRunResponse(req *http.Request, body []byte) *http.Response {
+	fmt.Println("Dry run, would send:")
+	fmt.Println(req.Method, redactURL(req.URL))
+
+	contentType := req.Header.Get("Content-Type")
+	if contentType != "" {
+		fmt.Println("Content-Type:", contentType)
+	}
+
+	if len(body) > 0 {
+		fmt.Printf("\n%s\n", describeBody(contentType, body))
+	}
+	fmt.Println()
+
+	header := http.Header{}
+	header.Set(dryRunHeader, "true")
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+}
+
+// isDryRun reports whether the response was made up by --dry-run
+func isDryRun(response *http.Response) bool {
+	return response.Header.Get(dryRunHeader) != ""
+}
+
+// describeBody the redacted body for review: indented JSON, or the fields of
+// a multipart form on a line each
+func describeBody(contentType string, body []byte) string {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" {
+		if fields, err := redactMultipart(body, params["boundary"]); err == nil {
+			return strings.Join(fields, "\n")
+		}
+	}
+
+	text := redactBody(contentType, body)
+	indented := &bytes.Buffer{}
+	if json.Indent(indented, []byte(text), "", "  ") == nil {
+		return indented.String()
+	}
+
+	return text
+}
+
+func init() {
+	RootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Print the changes that would be sent, without sending them")
+}
@@ -0,0 +1,79 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLeftOut(t *testing.T) {
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	tests := []struct {
+		method  string
+		body    string
+		leftOut bool
+	}{
+		{"GET", "", false},
+		{"HEAD", "", false},
+		{"OPTIONS", "", false},
+		{"POST", `{"environmentName": "org1:env1"}`, true},
+		{"PATCH", `{"replicas": 3}`, true},
+		{"DELETE", "", true},
+		{"POST", "grant_type=password&username=me&password=pw", true},
+		{"POST", "grant_type=refresh_token&refresh_token=rt", false},
+		{"POST", "%zz", true},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "https://api.example.com/environments", nil)
+		if got := leftOut(req, []byte(test.body)); got != test.leftOut {
+			t.Errorf("leftOut(%s %q) = %v, expected %v", test.method, test.body, got, test.leftOut)
+		}
+	}
+
+	dryRun = false
+	req, _ := http.NewRequest("DELETE", "https://api.example.com/environments/org1:env1", nil)
+	if leftOut(req, nil) {
+		t.Errorf("expected requests to be sent without --dry-run")
+	}
+}
+
+func TestDryRunResponse(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://api.example.com/environments?access_token=tk", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer tk")
+
+	var response *http.Response
+	stdout := captureStdout(t, func() {
+		response = dryRunResponse(req, []byte(`{"environmentName":"org1:env1","password":"pw"}`))
+	})
+
+	if !strings.Contains(stdout, "POST https://api.example.com/environments") ||
+		!strings.Contains(stdout, `"environmentName": "org1:env1"`) {
+		t.Errorf("dry run printed\n%s", stdout)
+	}
+
+	if strings.Contains(stdout, "tk") || strings.Contains(stdout, `"pw"`) {
+		t.Errorf("dry run printed credentials\n%s", stdout)
+	}
+
+	if response.StatusCode != http.StatusOK || !isDryRun(response) {
+		t.Errorf("expected a made up 200 response, got %+v", response)
+	}
+}
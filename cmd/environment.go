@@ -15,10 +15,6 @@
 package cmd
 
 import (
-	"fmt"
-	"log"
-	"io"
-	"os"
 	"bytes"
 	"encoding/json"
 	"net/http"
@@ -48,55 +44,38 @@ $ shipyardctl get environment org1:env1
 OR
 
 $ shipyardctl get environment org1:env1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
-		status := getEnvironment(envName)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := getEnvironment(envName)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
-		}
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return getEnvironment(envName)
+		})
+
+		return resourceError(status, err, "retrieve", "environment", envName, "")
 	},
 }
 
-func getEnvironment(envName string) int {
+func getEnvironment(envName string) (int, error) {
 	req, err := http.NewRequest("GET", clusterTarget + enroberPath + "/" + envName, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, printResponse(response)
 }
 
 var deleteEnvCmd = &cobra.Command{
@@ -104,60 +83,87 @@ var deleteEnvCmd = &cobra.Command{
 	Short: "deletes an active environment",
 	Long: `Given the name of an active environment, this will delete it.
 
+The environment must not contain any deployments, unless --cascade is given,
+in which case every deployment in it is deleted first. You will be asked to type
+the environment name to confirm, unless --yes is given.
+
 Example of use:
-$ shipyardctl delete environment org1:env1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+$ shipyardctl delete environment org1:env1 --token <token>
+
+$ shipyardctl delete environment org1:env1 --cascade --dry-run`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
-		status := deleteEnv(envName)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := deleteEnv(envName)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
+
+		var deployments []Deployment
+		status, err := retryIfUnauthorized(func() (status int, err error) {
+			deployments, status, err = listDeployments(envName)
+			return
+		})
+
+		if err = resourceError(status, err, "retrieve the deployments of", "environment", envName, ""); err != nil {
+			return err
+		}
+
+		if len(deployments) > 0 && !cascade {
+			return newError(exitConflict, "Environment %s still has %d deployment(s), use --cascade to delete them too", envName, len(deployments))
+		}
+
+		targets := []string{}
+		for _, dep := range deployments {
+			targets = append(targets, "deployment " + dep.DeploymentName + " in " + envName)
+		}
+		targets = append(targets, "environment " + envName)
+
+		if err := confirmDeletion(envName, targets); err != nil {
+			return err
+		}
+
+		for _, dep := range deployments {
+			name := dep.DeploymentName
+			status, err := retryIfUnauthorized(func() (int, error) {
+				return deleteDeployment(envName, name)
+			})
+
+			if err = checkStatus(status, err, "Failed to delete deployment %s. The environment was left in place", name); err != nil {
+				return err
 			}
 		}
+
+		status, err = retryIfUnauthorized(func() (int, error) {
+			return deleteEnv(envName)
+		})
+
+		return resourceError(status, err, "delete", "environment", envName, "")
 	},
 }
 
-func deleteEnv(envName string) int {
+func deleteEnv(envName string) (int, error) {
 	req, err := http.NewRequest("DELETE", clusterTarget + enroberPath + "/" + envName, nil)
-	if verbose {
-		PrintVerboseRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
+	response, err := doRequest(req)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-			PrintVerboseResponse(response)
-		}
-
 	defer response.Body.Close()
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		fmt.Println("\nDeletion of " + envName + " was successful\n")
-	}
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = printResponse(response)
+	if err == nil && isSuccess(response.StatusCode) {
+		printSuccess("Deletion of %s was successful", envName)
 	}
 
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 var createEnvCmd = &cobra.Command{
@@ -169,70 +175,52 @@ The environment name must be of the form {apigee_org}:{environment_name}.
 
 Example of use:
 $ shipyardctl create environment org1:env1 "test.host.name1" "test.host.name2" --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
 
 		if len(args) < 2 {
-			fmt.Println("Missing required arg(s) <hostnames...>")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg(s) <hostnames...>")
 		}
 
 		hostnames := args[1:]
 
-		status := createEnv(envName, hostnames)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := createEnv(envName, hostnames)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
-		}
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return createEnv(envName, hostnames)
+		})
+
+		return resourceError(status, err, "create", "environment", envName, "")
 	},
 }
 
-func createEnv(envName string, hostnames []string) int {
+func createEnv(envName string, hostnames []string) (int, error) {
 	js, _ := json.Marshal(Environment{envName, hostnames})
 
 	req, err := http.NewRequest("POST", clusterTarget + enroberPath, bytes.NewBuffer(js))
-
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		fmt.Println("\nCreation of " + envName + " was successful\n")
+	err = printResponse(response)
+	if err == nil && isSuccess(response.StatusCode) {
+		printSuccess("Creation of %s was successful", envName)
 	}
 
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 var patchEnvCmd = &cobra.Command{
@@ -244,75 +232,62 @@ will replace them entirely.
 
 Example of use:
 $ shipyardctl patch org1:env1 "test.host.name3" "test.host.name4" --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
 
 		if len(args) == 0 {
-			fmt.Println("Missing required arg <environmentName>\n")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg <environmentName>")
 		}
 
 		envName = args[0]
 
 		if len(args) < 2 {
-			fmt.Println("Missing required arg(s) <hostnames...>")
-			fmt.Println("Usage:\n\t" + cmd.Use + "\n")
-			return
+			return usageError(cmd, "Missing required arg(s) <hostnames...>")
 		}
 
 		hostnames := args[1:]
-		status := patchEnv(envName, hostnames)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := patchEnv(envName, hostnames)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
-		}
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return patchEnv(envName, hostnames)
+		})
+
+		return resourceError(status, err, "patch", "environment", envName, "")
 	},
 }
 
-func patchEnv(envName string, hostnames []string) int {
+func patchEnv(envName string, hostnames []string) (int, error) {
 	js, _ := json.Marshal(EnvironmentPatch{hostnames})
 
 	req, err := http.NewRequest("PATCH", clusterTarget + enroberPath + "/" + envName, bytes.NewBuffer(js))
-
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		fmt.Println("\nPatch of " + envName + " was successful\n")
-	}
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = printResponse(response)
+	if err == nil && isSuccess(response.StatusCode) {
+		printSuccess("Patch of %s was successful", envName)
 	}
 
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 func init() {
 	getCmd.AddCommand(environmentCmd)
 
 	deleteCmd.AddCommand(deleteEnvCmd)
+	deleteEnvCmd.Flags().BoolVar(&cascade, "cascade", false, "Delete all deployments in the environment first")
+	deleteEnvCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Skip the interactive confirmation")
 	createCmd.AddCommand(createEnvCmd)
 	patchCmd.AddCommand(patchEnvCmd)
+	withDryRun[createEnvCmd] = true
+	withDryRun[patchEnvCmd] = true
+	withDryRun[deleteEnvCmd] = true
 }
@@ -0,0 +1,127 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// exit codes, so that scripts can tell why a command failed
+const (
+	exitError = 1 // any other failure
+	exitUsage = 2 // missing or invalid arguments or flags
+	exitAuth = 3 // not logged in, or the credentials or token were rejected
+	exitNotFound = 4 // the API responded 404
+	exitConflict = 5 // the API responded 409, e.g. the resource exists already
+	exitServer = 6 // the API responded with a 5xx status
+	exitNetwork = 7 // the API could not be reached
+)
+
+// cmdError an error that decides the exit code of the command
+type cmdError struct {
+	Code int
+	Message string
+}
+
+func (e *cmdError) Error() string {
+	return e.Message
+}
+
+// newError creates an error exiting with the given code
+func newError(code int, format string, a ...interface{}) error {
+	return &cmdError{code, fmt.Sprintf(format, a...)}
+}
+
+// usageError reports missing or invalid arguments, along with the usage of the command
+func usageError(cmd *cobra.Command, format string, a ...interface{}) error {
+	return newError(exitUsage, "%s\n\nUsage:\n\t%s", fmt.Sprintf(format, a...), cmd.UseLine())
+}
+
+// authError reports a failure to authenticate
+func authError(format string, a ...interface{}) error {
+	return newError(exitAuth, format, a...)
+}
+
+// networkError reports a failure to reach the API
+func networkError(err error) error {
+	if _, ok := err.(*cmdError); ok {
+		return err
+	}
+
+	return newError(exitNetwork, "%v", err)
+}
+
+// statusError reports a failed API call, with the exit code following the response status
+func statusError(status int, format string, a ...interface{}) error {
+	return newError(statusExitCode(status), "%s (status %d)", fmt.Sprintf(format, a...), status)
+}
+
+// statusExitCode the exit code for a failed API call with the given response status
+func statusExitCode(status int) int {
+	switch {
+	case status == 401 || status == 403:
+		return exitAuth
+	case status == 404:
+		return exitNotFound
+	case status == 409:
+		return exitConflict
+	case status >= 500:
+		return exitServer
+	}
+
+	return exitError
+}
+
+// checkStatus turns the outcome of an API call into an error unless it succeeded,
+// prefixing the message of an API error response with what was attempted
+func checkStatus(status int, err error, format string, a ...interface{}) error {
+	if apiErr, ok := err.(*APIError); ok {
+		return newError(statusExitCode(apiErr.Status), "%s: %v (status %d)", fmt.Sprintf(format, a...), apiErr, apiErr.Status)
+	} else if err != nil {
+		return err
+	}
+
+	if status < 200 || status >= 300 {
+		return statusError(status, format, a...)
+	}
+
+	return nil
+}
+
+// unexpected reports whether the error is anything but an error response of the
+// API, for callers that handle failed statuses themselves
+func unexpected(err error) bool {
+	_, ok := err.(*APIError)
+	return err != nil && !ok
+}
+
+// isSuccess reports whether the status is a 2xx one
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// exitCode the code the process exits with for the given error
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case *cmdError:
+		return e.Code
+	case *APIError:
+		return statusExitCode(e.Status)
+	}
+
+	return exitError
+}
@@ -0,0 +1,67 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{newError(exitUsage, "bad flag"), exitUsage},
+		{authError("Not logged in"), exitAuth},
+		{networkError(errors.New("connection refused")), exitNetwork},
+		{networkError(newError(exitAuth, "rejected")), exitAuth},
+		{statusError(401, "Failed"), exitAuth},
+		{statusError(403, "Failed"), exitAuth},
+		{statusError(404, "Failed"), exitNotFound},
+		{statusError(409, "Failed"), exitConflict},
+		{statusError(502, "Failed"), exitServer},
+		{statusError(400, "Failed"), exitError},
+		{&APIError{Status: 404}, exitNotFound},
+		{errors.New("anything else"), exitError},
+	}
+
+	for _, test := range tests {
+		if code := exitCode(test.err); code != test.code {
+			t.Errorf("exitCode(%v) = %d, expected %d", test.err, code, test.code)
+		}
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	if err := checkStatus(201, nil, "Failed to create %s", "env1"); err != nil {
+		t.Errorf("expected no error for a 201, got %v", err)
+	}
+
+	err := checkStatus(500, nil, "Failed to create %s", "env1")
+	if err == nil || err.Error() != "Failed to create env1 (status 500)" || exitCode(err) != exitServer {
+		t.Errorf("unexpected error for a 500: %v", err)
+	}
+
+	err = checkStatus(409, &APIError{Status: 409, Message: "exists"}, "Failed to create %s", "env1")
+	if err == nil || err.Error() != "Failed to create env1: exists (status 409)" || exitCode(err) != exitConflict {
+		t.Errorf("unexpected error for a 409 response: %v", err)
+	}
+
+	other := errors.New("connection refused")
+	if err = checkStatus(0, other, "Failed"); err != other {
+		t.Errorf("expected the error to be returned as is, got %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+var recordFile string
+var replayFile string
+
+// the exchanges recorded so far, or to replay, once --record or --replay is used
+var recording *harFile
+var replaying *harFile
+
+// harFile an HTTP Archive, see http://www.softwareishard.com/blog/har-12-spec/
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string `json:"startedDateTime"`
+	Time float64 `json:"time"`
+	Request harRequest `json:"request"`
+	Response harResponse `json:"response"`
+	Cache struct{} `json:"cache"`
+	Timings harTimings `json:"timings"`
+
+	replayed bool
+}
+
+type harRequest struct {
+	Method string `json:"method"`
+	URL string `json:"url"`
+	HTTPVersion string `json:"httpVersion"`
+	Cookies []harNameValue `json:"cookies"`
+	Headers []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData *harPostData `json:"postData,omitempty"`
+	HeadersSize int `json:"headersSize"`
+	BodySize int `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status int `json:"status"`
+	StatusText string `json:"statusText"`
+	HTTPVersion string `json:"httpVersion"`
+	Cookies []harNameValue `json:"cookies"`
+	Headers []harNameValue `json:"headers"`
+	Content harContent `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int `json:"headersSize"`
+	BodySize int `json:"bodySize"`
+	Error string `json:"_error,omitempty"` // the call failed without a response
+	Transient bool `json:"_transient,omitempty"` // and was retried
+}
+
+// replayedError a recorded failure of a call, retried on replay as it was when recorded
+type replayedError struct {
+	message string
+	transient bool
+}
+
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+type harNameValue struct {
+	Name string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text string `json:"text"`
+}
+
+type harContent struct {
+	Size int `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send float64 `json:"send"`
+	Wait float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// roundTrip sends the request, or with --replay answers it from the recording,
+// adding the exchange to the recording with --record
+func roundTrip(client *http.Client, req *http.Request, body []byte) (*http.Response, error) {
+	if replayFile != "" && recordFile != "" {
+		return nil, newError(exitUsage, "--record and --replay cannot be used together")
+	} else if replayFile != "" {
+		return replay(req)
+	}
+
+	start := time.Now()
+	response, err := client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = redactURL(req.URL) // keep secrets out of the error message
+	}
+
+	if recordFile != "" {
+		if recordErr := record(req, body, response, err, start); recordErr != nil {
+			logV(logInfo, "unable to record the API call", "file", recordFile, "error", recordErr)
+		}
+	}
+
+	return response, err
+}
+
+// record adds the exchange, without credentials, to the recording and writes it out,
+// so that it is complete whenever and however the command ends
+func record(req *http.Request, body []byte, response *http.Response, callErr error, start time.Time) error {
+	if recording == nil {
+		recording = &harFile{harLog{Version: "1.2", Creator: harCreator{"shipyardctl", Version}, Entries: []harEntry{}}}
+	}
+
+	took := float64(time.Since(start)) / float64(time.Millisecond)
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time: took,
+		Request: harRequest{
+			Method: req.Method,
+			URL: redactURL(req.URL),
+			HTTPVersion: "HTTP/1.1",
+			Cookies: []harNameValue{},
+			Headers: harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize: len(body),
+		},
+		Response: harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		Timings: harTimings{Send: 0, Wait: took, Receive: 0},
+	}
+
+	if redactedURL, err := url.Parse(entry.Request.URL); err == nil {
+		for name, values := range redactedURL.Query() {
+			for _, value := range values {
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+			}
+		}
+	}
+
+	if len(body) > 0 {
+		contentType := req.Header.Get("Content-Type")
+		entry.Request.PostData = &harPostData{MimeType: contentType, Text: redactBody(contentType, body)}
+	}
+
+	if callErr != nil {
+		entry.Response.Error = callErr.Error()
+		entry.Response.Transient = isTransientError(callErr)
+	} else {
+		content, err := readAndRestore(response)
+		if err != nil {
+			return err
+		}
+
+		contentType := response.Header.Get("Content-Type")
+		entry.Response.Status = response.StatusCode
+		entry.Response.StatusText = http.StatusText(response.StatusCode)
+		entry.Response.HTTPVersion = response.Proto
+		entry.Response.Headers = harHeaders(response.Header)
+		entry.Response.BodySize = len(content)
+		entry.Response.Content = harContent{Size: len(content), MimeType: contentType}
+		if utf8.Valid(content) {
+			entry.Response.Content.Text = redactBody(contentType, content)
+		} else {
+			entry.Response.Content.Text = base64.StdEncoding.EncodeToString(content)
+			entry.Response.Content.Encoding = "base64"
+		}
+	}
+
+	recording.Log.Entries = append(recording.Log.Entries, entry)
+
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(recordFile, data, 0600)
+}
+
+// harHeaders the redacted headers, sorted by name
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range redactHeader(header) {
+		for _, value := range values {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+
+	sort.Sort(byName(headers))
+	return headers
+}
+
+type byName []harNameValue
+
+func (h byName) Len() int { return len(h) }
+func (h byName) Less(i, j int) bool { return h[i].Name < h[j].Name }
+func (h byName) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// replay answers the request with the first recorded response to the same method,
+// path and query not replayed yet, so that repeated calls, e.g. retries or polls, get
+// their responses in the order they were recorded. The host is left out, so that a
+// recording can be replayed against any cluster target.
+func replay(req *http.Request) (*http.Response, error) {
+	if replaying == nil {
+		data, err := ioutil.ReadFile(replayFile)
+		if err != nil {
+			return nil, newError(exitUsage, "Unable to read the recording: %v", err)
+		}
+
+		replaying = &harFile{}
+		if err = json.Unmarshal(data, replaying); err != nil {
+			return nil, newError(exitUsage, "Unable to read the recording %s: %v", replayFile, err)
+		}
+	}
+
+	target := redactURL(req.URL)
+	for ndx := range replaying.Log.Entries {
+		entry := &replaying.Log.Entries[ndx]
+		if entry.replayed || entry.Request.Method != req.Method || requestURI(entry.Request.URL) != requestURI(target) {
+			continue
+		}
+		entry.replayed = true
+
+		if entry.Response.Error != "" {
+			return nil, &replayedError{entry.Response.Error, entry.Response.Transient}
+		}
+
+		content := []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to decode the recorded response to %s %s: %v", req.Method, target, err)
+			}
+			content = decoded
+		}
+
+		header := http.Header{}
+		for _, h := range entry.Response.Headers {
+			header.Add(h.Name, h.Value)
+		}
+
+		return &http.Response{
+			Status: fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+			StatusCode: entry.Response.Status,
+			Proto: "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: header,
+			Body: ioutil.NopCloser(bytes.NewReader(content)),
+			ContentLength: int64(len(content)),
+			Request: req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("No recorded response to %s %s left in %s", req.Method, target, replayFile)
+}
+
+// requestURI the path and query of the URL
+func requestURI(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	return parsed.RequestURI()
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&recordFile, "record", "", "Record every API call and response, without credentials, to the given HAR file")
+	RootCmd.PersistentFlags().StringVar(&replayFile, "replay", "", "Answer API calls from the given HAR file, recorded with --record, instead of calling the APIs")
+}
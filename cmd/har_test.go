@@ -0,0 +1,114 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+const recordedCalls = `{"log": {"version": "1.2", "entries": [
+	{"request": {"method": "GET", "url": "https://recorded.example.com/environments/org1:env1"},
+	 "response": {"_error": "dial tcp: connection refused", "_transient": true}},
+	{"request": {"method": "GET", "url": "https://recorded.example.com/environments/org1:env1"},
+	 "response": {"status": 200, "statusText": "OK", "headers": [{"name": "Content-Type", "value": "application/json"}],
+	  "content": {"mimeType": "application/json", "text": "{\"environmentName\":\"org1:env1\"}"}}},
+	{"request": {"method": "GET", "url": "https://recorded.example.com/imagespaces/org1/images"},
+	 "response": {"status": 200, "statusText": "OK", "content": {"encoding": "base64", "text": "W10="}}}
+]}}`
+
+// useRecording replays the given HAR file contents, returning a function to stop
+func useRecording(t *testing.T, har string) func() {
+	file, err := ioutil.TempFile("", "shipyardctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(har)
+	file.Close()
+
+	replayFile = file.Name()
+	replaying = nil
+
+	return func() {
+		replayFile = ""
+		replaying = nil
+		os.Remove(file.Name())
+	}
+}
+
+func replayBody(t *testing.T, response *http.Response) string {
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestReplayInOrder(t *testing.T) {
+	defer useRecording(t, recordedCalls)()
+
+	// the host is left out, so the recording can be replayed against any cluster
+	req, _ := http.NewRequest("GET", "http://localhost:8080/environments/org1:env1", nil)
+
+	_, err := replay(req)
+	if err == nil || !isTransient(nil, err) {
+		t.Fatalf("the recorded failure replayed as %v, expected it to be retried", err)
+	}
+
+	response, err := replay(req)
+	if err != nil {
+		t.Fatalf("replay of the retry: %v", err)
+	}
+
+	if response.StatusCode != 200 || response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("replayed status %d and headers %v", response.StatusCode, response.Header)
+	}
+
+	if body := replayBody(t, response); body != `{"environmentName":"org1:env1"}` {
+		t.Errorf("replayed body %s", body)
+	}
+
+	if _, err = replay(req); err == nil {
+		t.Errorf("a call was replayed more often than it was recorded")
+	}
+}
+
+func TestReplayDecodesBinaryBodies(t *testing.T) {
+	defer useRecording(t, recordedCalls)()
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/imagespaces/org1/images", nil)
+	response, err := replay(req)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	if body := replayBody(t, response); body != "[]" {
+		t.Errorf("replayed base64 body as %s", body)
+	}
+}
+
+func TestReplayWithoutRecording(t *testing.T) {
+	defer useRecording(t, recordedCalls)()
+	replayFile = replayFile + ".missing"
+
+	req, _ := http.NewRequest("GET", "http://localhost:8080/environments/org1:env1", nil)
+	if _, err := replay(req); exitCode(err) != exitUsage {
+		t.Errorf("replay of a missing recording failed with %v, expected a usage error", err)
+	}
+}
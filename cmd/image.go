@@ -21,13 +21,44 @@ import (
 	"mime/multipart"
 	"bytes"
 	"os"
-	"log"
 	"path/filepath"
+	"regexp"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var nodeVersion string
+var buildTimeout time.Duration
+
+// ImageRef identifies a built image by its imagespace, application name and revision
+type ImageRef struct {
+	Org string
+	AppName string
+	Revision string
+}
+
+// matches the image portion of a PTS URL, e.g. .../imagespaces/org1/images/example/version/1/podtemplatespec
+var ptsUrlPattern = regexp.MustCompile(`/imagespaces/([^/]+)/images/([^/]+)/version/([^/?]+)`)
+
+// parsePtsUrl extracts the image a PTS URL refers to
+func parsePtsUrl(ptsUrl string) (ImageRef, bool) {
+	match := ptsUrlPattern.FindStringSubmatch(ptsUrl)
+	if match == nil {
+		return ImageRef{}, false
+	}
+
+	return ImageRef{match[1], match[2], match[3]}, true
+}
+
+// Path returns the build service path of the image revision
+func (ref ImageRef) Path() string {
+	return fmt.Sprintf("/imagespaces/%s/images/%s/version/%s", ref.Org, ref.AppName, ref.Revision)
+}
+
+func (ref ImageRef) String() string {
+	return ref.Org + "/" + ref.AppName + "/" + ref.Revision
+}
 
 // imageCmd represents the image command
 var imageCmd = &cobra.Command{
@@ -41,15 +72,17 @@ Within the project zip, there must be a valid package.json.
 Example of use:
 
 $ shipyardctl create image example 1 "9000:/example" "./path/to/zipped/app --org org1 --token <token>"`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
-		RequireOrgName()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		if err := RequireOrgName(cmd); err != nil {
+			return err
+		}
 		MakeBuildPath()
 
 		if len(args) < 4 {
-			fmt.Println("Missing required args\n")
-			fmt.Println("Usage:\n\t "+cmd.Use+"\n")
-			return
+			return usageError(cmd, "Missing required args")
 		}
 
 		appName := args[0]
@@ -57,22 +90,23 @@ $ shipyardctl create image example 1 "9000:/example" "./path/to/zipped/app --org
 		publicPath := args[2]
 		zipPath := args[3]
 
-		status := createImage(appName, revision, publicPath, zipPath)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status = createImage(appName, revision, publicPath, zipPath)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
+		// a build takes much longer than any other call
+		if !cmd.Flags().Changed("request-timeout") {
+			requestTimeout = buildTimeout
 		}
+
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return createImage(appName, revision, publicPath, zipPath)
+		})
+
+		return resourceError(status, err, "build", "image", appName + " revision " + revision, orgName)
 	},
 }
 
-func createImage(appName string, revision string, publicPath string, zipPath string) int {
+func createImage(appName string, revision string, publicPath string, zipPath string) (int, error) {
 	zip, err := os.Open(zipPath)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 	defer zip.Close()
 
@@ -80,9 +114,12 @@ func createImage(appName string, revision string, publicPath string, zipPath str
 	writer := multipart.NewWriter(body)
 	part, err := writer.CreateFormFile("file", filepath.Base(zipPath))
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 	_, err = io.Copy(part, zip)
+	if err != nil {
+		return 0, err
+	}
 
 	if len(envVars) > 0 {
 		for i := range envVars {
@@ -97,44 +134,28 @@ func createImage(appName string, revision string, publicPath string, zipPath str
 
 	err = writer.Close()
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	req, err := http.NewRequest("POST", clusterTarget + basePath, body)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	if verbose {
-		PrintVerboseRequest(req)
+		return 0, err
 	}
 
-	req.Header.Set("Authorization", "Bearer " + authToken)
 	req.Header.Add("Content-Type", writer.FormDataContentType())
-	response, err := http.DefaultClient.Do(req)
-
+	response, err := doRequest(req)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	if verbose {
-		PrintVerboseResponse(response)
+		return 0, err
 	}
 
 	// dump response to stdout
 	defer response.Body.Close()
-	if response.StatusCode == 201 {
-		fmt.Println("\nImage build successful\n")
-	}
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = printResponse(response)
+	if err == nil && response.StatusCode == 201 {
+		printSuccess("Image build successful")
 	}
 
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 var getImageCmd = &cobra.Command{
@@ -151,107 +172,72 @@ $ shipyardctl get image example 1
 OR
 
 $ shipyardctl get image example --all --org org1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
-		RequireOrgName()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		if err := RequireOrgName(cmd); err != nil {
+			return err
+		}
 		MakeBuildPath()
 
 		if all {
 			if len(args) < 1 {
-				fmt.Println("Missing application name\n")
-				return
+				return usageError(cmd, "Missing application name")
 			}
 
 			appName := args[0]
 
-			status := getImageAll(appName)
-			if !CheckIfAuthn(status) {
-				// retry once more
-				status := getImageAll(appName)
-				if status == 401 {
-					fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-					fmt.Println("Command failed.")
-				}
-			}
-		} else {
-			if len(args) < 2 {
-				fmt.Println("Missing required args\n")
-				fmt.Println("Usage:\n\t "+cmd.Use+"\n")
-				return
-			}
+			status, err := retryIfUnauthorized(func() (int, error) {
+				return getImageAll(appName)
+			})
 
-			appName := args[0]
-			revision := args[1]
-
-			status := getImageRevision(appName, revision)
-			if !CheckIfAuthn(status) {
-				// retry once more
-				status := getImageRevision(appName, revision)
-				if status == 401 {
-					fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-					fmt.Println("Command failed.")
-				}
-			}
+			return checkStatus(status, err, "Failed to retrieve the images of %s", appName)
+		}
+
+		if len(args) < 2 {
+			return usageError(cmd, "Missing required args")
 		}
+
+		appName := args[0]
+		revision := args[1]
+
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return getImageRevision(appName, revision)
+		})
+
+		return resourceError(status, err, "retrieve", "image", appName + " revision " + revision, orgName)
 	},
 }
 
-func getImageRevision(appName string, revision string) int {
+func getImageRevision(appName string, revision string) (int, error) {
 	req, err := http.NewRequest("GET", clusterTarget + basePath + "/" + appName + "/version/"+revision, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, printResponse(response)
 }
 
-func getImageAll(appName string) int {
+func getImageAll(appName string) (int, error) {
 	req, err := http.NewRequest("GET", clusterTarget + basePath + "/" + appName, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	return response.StatusCode
+	return response.StatusCode, printResponse(response)
 }
 
 var deleteImageCmd = &cobra.Command{
@@ -264,63 +250,49 @@ The image must've be built by a successful 'shipyardctl build image' command
 Example of use:
 
 $ shipyardctl delete image example 1 --org org1 --token <token>`,
-	Run: func(cmd *cobra.Command, args []string) {
-		RequireAuthToken()
-		RequireOrgName()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		if err := RequireOrgName(cmd); err != nil {
+			return err
+		}
 		MakeBuildPath()
 
 		if len(args) < 2 {
-			fmt.Println("Missing required args\n")
-			fmt.Println("Usage:\n\t "+cmd.Use+"\n")
-			return
+			return usageError(cmd, "Missing required args")
 		}
 
 		appName := args[0]
 		revision := args[1]
 
-		status := deleteImage(appName, revision)
-		if !CheckIfAuthn(status) {
-			// retry once more
-			status := deleteImage(appName, revision)
-			if status == 401 {
-				fmt.Println("Unable to authenticate. Please check your SSO target URL is correct.")
-				fmt.Println("Command failed.")
-			}
-		}
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return deleteImage(appName, revision)
+		})
+
+		return resourceError(status, err, "delete", "image", appName + " revision " + revision, orgName)
 	},
 }
 
-func deleteImage(appName string, revision string) int {
+func deleteImage(appName string, revision string) (int, error) {
 	req, err := http.NewRequest("DELETE", clusterTarget + basePath + "/" + appName + "/version/"+revision, nil)
-	if verbose {
-		PrintVerboseRequest(req)
-	}
-
-	req.Header.Set("Authorization", "Bearer " + authToken)
-	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	if verbose {
-		PrintVerboseResponse(response)
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
 	}
 
 	defer response.Body.Close()
 
-	if response.StatusCode == 200 {
-		fmt.Println("Deletion of image successful.")
-	}
-
-	if response.StatusCode != 401 {
-		_, err = io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = printResponse(response)
+	if err == nil && response.StatusCode == 200 {
+		printSuccess("Deletion of image successful")
 	}
 
-	return response.StatusCode
+	return response.StatusCode, err
 }
 
 func init() {
@@ -328,6 +300,7 @@ func init() {
 	imageCmd.Flags().StringSliceVar(&envVars, "env-var", []string{}, "Environment variable to set in the built image \"KEY=VAL\" ")
 	imageCmd.Flags().StringVarP(&orgName, "org", "o", "", "Apigee org name")
 	imageCmd.Flags().StringVarP(&nodeVersion, "node-version", "n", "4", "Node version to use in base image.")
+	imageCmd.Flags().DurationVar(&buildTimeout, "build-timeout", 15 * time.Minute, "Maximum time the build may take, unless --request-timeout is given")
 
 	getCmd.AddCommand(getImageCmd)
 	getImageCmd.Flags().StringVarP(&orgName, "org", "o", "", "Apigee org name")
@@ -335,4 +308,6 @@ func init() {
 
 	deleteCmd.AddCommand(deleteImageCmd)
 	deleteImageCmd.Flags().StringVarP(&orgName, "org", "o", "", "Apigee org name")
+	withDryRun[imageCmd] = true
+	withDryRun[deleteImageCmd] = true
 }
@@ -0,0 +1,199 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verbosity levels, each including the ones below it
+const (
+	logInfo = 1 // the context in use, retries, error response bodies and progress
+	logCalls = 2 // every API call with its status and duration
+	logHeaders = 4 // request and response headers, the level of a bare -v
+	logBodies = 6 // request and response bodies, truncated
+	logCurl = 8 // a curl command for every API call, like --print-curl
+	logFullBodies = 9 // request and response bodies in full
+)
+
+// longest body logged below logFullBodies
+const maxLoggedBody = 2048
+
+var verbosity int
+
+// where log messages go
+var logOutput io.Writer = os.Stderr
+
+// logging reports whether messages of the given level are logged
+func logging(level int) bool {
+	return verbosity >= level
+}
+
+// logV writes a message to stderr when the verbosity is at least the given level,
+// as a line of key=value pairs, e.g.
+// time=12:00:00.000 level=2 msg="API call" method=GET url=https://... status=200
+func logV(level int, msg string, keyvals ...interface{}) {
+	if !logging(level) {
+		return
+	}
+
+	line := &bytes.Buffer{}
+	fmt.Fprintf(line, "time=%s level=%d msg=%s", time.Now().Format("15:04:05.000"), level, logValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = ""
+		if i + 1 < len(keyvals) {
+			value = keyvals[i + 1]
+		}
+
+		fmt.Fprintf(line, " %v=%s", keyvals[i], logValue(fmt.Sprint(value)))
+	}
+
+	line.WriteString("\n")
+	logOutput.Write(line.Bytes())
+}
+
+// logValue quotes a value unless it is a single plain word
+func logValue(value string) string {
+	if value == "" || strings.IndexFunc(value, needsQuote) >= 0 {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
+func needsQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == 0x7f || !strconv.IsPrint(r)
+}
+
+// logHeaderValues the redacted headers as key/value pairs for logV, sorted by name
+func logHeaderValues(header http.Header) []interface{} {
+	names := []string{}
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	redacted := redactHeader(header)
+	keyvals := []interface{}{}
+	for _, name := range names {
+		keyvals = append(keyvals, name, strings.Join(redacted[name], ", "))
+	}
+
+	return keyvals
+}
+
+// logBody the redacted body, truncated below logFullBodies
+func logBody(contentType string, body []byte) string {
+	text := redactBody(contentType, body)
+	if !logging(logFullBodies) && len(text) > maxLoggedBody {
+		text = fmt.Sprintf("%s... (%d more bytes, use -v=%d to see all)", text[:maxLoggedBody], len(text) - maxLoggedBody, logFullBodies)
+	}
+
+	return text
+}
+
+// logRequest logs the headers and body of a request about to be sent
+func logRequest(req *http.Request, body []byte) {
+	logContext()
+
+	if logging(logHeaders) {
+		keyvals := append([]interface{}{"method", req.Method, "url", redactURL(req.URL)}, logHeaderValues(req.Header)...)
+		logV(logHeaders, "request headers", keyvals...)
+	}
+
+	if logging(logBodies) && len(body) > 0 {
+		logV(logBodies, "request body", "body", logBody(req.Header.Get("Content-Type"), body))
+	}
+}
+
+// logResponse logs the outcome of an API call, reading the body to log it
+// at logBodies and putting it back for the caller
+func logResponse(req *http.Request, response *http.Response, err error, took time.Duration) {
+	if err != nil {
+		logV(logCalls, "API call", "method", req.Method, "url", redactURL(req.URL), "error", err, "duration", took / time.Millisecond * time.Millisecond)
+		return
+	}
+
+	logV(logCalls, "API call", "method", req.Method, "url", redactURL(req.URL), "status", response.StatusCode, "duration", took / time.Millisecond * time.Millisecond)
+	if logging(logHeaders) {
+		logV(logHeaders, "response headers", logHeaderValues(response.Header)...)
+	}
+
+	if logging(logBodies) {
+		body, err := readAndRestore(response)
+		if err != nil {
+			logV(logBodies, "unable to read the response body", "error", err)
+		} else if len(body) > 0 {
+			logV(logBodies, "response body", "body", logBody(response.Header.Get("Content-Type"), body))
+		}
+	}
+}
+
+// readAndRestore reads the body of the response and replaces it with a copy
+func readAndRestore(response *http.Response) ([]byte, error) {
+	body := &bytes.Buffer{}
+	_, err := body.ReadFrom(response.Body)
+	response.Body.Close()
+	response.Body = ioutil.NopCloser(bytes.NewReader(body.Bytes()))
+	return body.Bytes(), err
+}
+
+// name of the context last logged, it is logged again when commands switch contexts
+var loggedContext *string
+
+// logContext logs where the context, cluster, SSO target and org in use come from
+func logContext() {
+	if !logging(logInfo) || config == nil {
+		return
+	}
+
+	context := config.GetCurrentContext()
+	if context == nil || (loggedContext != nil && *loggedContext == context.Name) {
+		return
+	}
+	loggedContext = &context.Name
+
+	keyvals := []interface{}{"name", context.Name}
+	keyvals = append(keyvals, sourceOf("cluster", clusterTargetFlag, "CLUSTER_TARGET", context.ClusterInfo.Cluster)...)
+	keyvals = append(keyvals, sourceOf("sso", ssoTargetFlag, "SSO_LOGIN_URL", context.ClusterInfo.SSO)...)
+	keyvals = append(keyvals, sourceOf("org", orgName, "APIGEE_ORG", config.GetCurrentOrg())...)
+	if envName != "" {
+		keyvals = append(keyvals, "env", envName)
+	}
+
+	logV(logInfo, "context", keyvals...)
+}
+
+// sourceOf the value of a setting and whether it comes from a flag, the environment or the config file
+func sourceOf(key string, flag string, envVar string, configured string) []interface{} {
+	if flag != "" {
+		return []interface{}{key, flag, key + "_from", "flag"}
+	} else if value := os.Getenv(envVar); value != "" {
+		return []interface{}{key, value, key + "_from", envVar}
+	} else if configured != "" {
+		return []interface{}{key, configured, key + "_from", "config"}
+	}
+
+	return nil
+}
@@ -20,11 +20,12 @@ import (
   "io/ioutil"
 	"os"
   "bufio"
-	"log"
   "fmt"
   "strings"
   "bytes"
   "encoding/json"
+  "encoding/base64"
+  "time"
 
 	"github.com/spf13/cobra"
   "github.com/howeyc/gopass"
@@ -34,136 +35,338 @@ import (
 var username string
 var password string
 var mfa string
+var usePasscode bool
+var passcode string
+var loginClientID string
+var loginClientSecret string
 
 type AuthResponse struct {
   Access_token string `json:"access_token"`
+  Refresh_token string `json:"refresh_token"`
+  Expires_in int64 `json:"expires_in"`
 }
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "get new auth token",
 	Long: `This retrieves a new JWT token based on Apigee credentials.
+The refresh token issued along with it is stored as well, so that an expired
+token can be renewed without asking for the credentials again.
+
+Users of SAML based single sign-on login with a one-time passcode instead of a
+password, using --passcode. Service accounts login with the client credentials
+of their OAuth client, using --client-id and --client-secret.
+
+Nothing is prompted for when all required values are given as flags or
+environment variables, so login can run unattended in CI:
+APIGEE_USERNAME, APIGEE_PASSWORD and APIGEE_MFA, or APIGEE_PASSCODE,
+or APIGEE_CLIENT_ID and APIGEE_CLIENT_SECRET.
 
 Example of use:
 
-$ shipyardctl login -u orgAdmin@apigee.com`,
-	Run: func(cmd *cobra.Command, args []string) {
-    Login()
+$ shipyardctl login -u orgAdmin@apigee.com
+
+$ shipyardctl login --passcode
 
-    return
+$ shipyardctl login --client-id ci-bot --client-secret $SECRET`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+    return Login()
 	},
 }
 
-func Login() {
-  requireUsername()
-  requirePassword()
-  askForMFA()
+func Login() error {
+  data := url.Values{}
+  service, err := requireClientCredentials()
+  if err != nil {
+    return err
+  }
+
+  if service { // service account
+    data.Add("grant_type", "client_credentials")
+    username = loginClientID
+  } else if usePasscode {
+    if err = requirePasscode(); err != nil {
+      return err
+    }
+    data.Add("passcode", passcode)
+    data.Add("grant_type", "password")
+  } else {
+    if err = requireUsername(); err != nil {
+      return err
+    }
+    if err = requirePassword(); err != nil {
+      return err
+    }
+    if err = askForMFA(); err != nil {
+      return err
+    }
+
+    data.Add("username", username)
+    data.Add("password", password)
+    data.Add("grant_type", "password")
+  }
+
+  auth, status, err := requestToken(data)
+  if unexpected(err) {
+    return err
+  } else if status != 200 {
+    return authError("Invalid credentials. Failed to login: %s", describeStatus(status, err))
+  } else if dryRun {
+    return nil
+  }
+
+  if usePasscode {
+    if claims, err := utils.DecodeToken(auth.Access_token); err == nil {
+      username = claims.UserName
+    }
+  }
+
+  logV(logInfo, "received an access token", "user", username, "expires_in", auth.Expires_in, "refresh_token", auth.Refresh_token != "")
+
+  fmt.Println("Writing credentials to current context")
+
+  err = saveCredentials(username, auth, "")
+  if err != nil {
+    return fmt.Errorf("Failed to write credentials to file: %v", err)
+  }
+
+  if path := config.Path(); path != "" {
+    fmt.Println("Successfully wrote credentials to", path)
+  } else if replayFile != "" {
+    fmt.Println("Credentials are kept for this command only, while replaying.")
+  } else {
+    fmt.Println("Credentials are kept for this command only, there is no config file.")
+  }
+
+  return nil
+}
+
+// RefreshLogin exchanges the refresh token of the current context for a new
+// access token, reporting whether that worked
+func RefreshLogin() bool {
+  if config == nil {
+    return false
+  }
+
+  refreshToken := config.GetCurrentRefreshToken()
+  if refreshToken == "" {
+    return false
+  }
 
   data := url.Values{}
-  data.Add("username", username)
-  data.Add("password", password)
-  data.Add("grant_type", "password")
+  data.Add("refresh_token", refreshToken)
+  data.Add("grant_type", "refresh_token")
+
+  auth, status, err := requestToken(data)
+  if err != nil || status != 200 {
+    logV(logInfo, "refreshing the token failed", "error", checkStatus(status, err, "rejected"))
+
+    return false
+  }
+
+  err = saveCredentials(config.GetCurrentUsername(), auth, refreshToken)
+  if err != nil {
+    fmt.Println("Failed to write refreshed credentials to file:", err)
+    return false
+  }
+
+  logV(logInfo, "refreshed the auth token of the current context")
+
+  return true
+}
+
+// requestToken posts the given grant to the SSO token endpoint
+func requestToken(data url.Values) (*AuthResponse, int, error) {
   payload := bytes.NewBufferString(data.Encode())
-  clientAuth := "ZWRnZWNsaTplZGdlY2xpc2VjcmV0"
 
   var req *http.Request
   var err error
-  if mfa == "" {
+  if mfa == "" || data.Get("grant_type") != "password" {
     req, err = http.NewRequest("POST", sso_target + "/oauth/token", payload)
   } else {
     req, err = http.NewRequest("POST", sso_target + "/oauth/token?mfa_token="+mfa, payload)
   }
+  if err != nil {
+    return nil, 0, err
+  }
+
+  // service accounts authenticate as their own client, everyone else through the context's
+  id, secret := config.GetCurrentOAuthClient()
+  if data.Get("grant_type") == "client_credentials" {
+    id, secret = loginClientID, loginClientSecret
+  }
 
-  req.Header.Set("Authorization", "Basic " + clientAuth)
+  req.Header.Set("Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(id + ":" + secret)))
   req.Header.Add("Content-Type", "application/x-www-form-urlencoded;charset=utf-8")
   req.Header.Add("Accept", "application/json;charset=utf-8")
 
-  response, err := http.DefaultClient.Do(req)
+  response, err := sendRequest(req)
 
   if err != nil {
-    log.Fatal(err)
-  }
-
-  if response.StatusCode != 200 {
-    fmt.Println("Invalid credentials. Failed to login.")
-    os.Exit(-1)
+    return nil, 0, networkError(err)
   }
 
   defer response.Body.Close()
   body, err := ioutil.ReadAll(response.Body)
   if err != nil {
-    log.Fatal(err)
+    return nil, 0, networkError(err)
   }
 
-  auth := AuthResponse{}
-  err = json.Unmarshal(body, &auth)
-  if err != nil {
-    log.Fatal(err)
+  if response.StatusCode != 200 {
+    return nil, response.StatusCode, parseAPIError(response.StatusCode, body)
+  } else if isDryRun(response) {
+    return &AuthResponse{}, response.StatusCode, nil
   }
 
-  if verbose {
-    fmt.Println("Authorization token:")
-    fmt.Println(auth.Access_token)
+  auth := &AuthResponse{}
+  err = json.Unmarshal(body, auth)
+  if err != nil {
+    return nil, response.StatusCode, fmt.Errorf("Unable to read the SSO response: %v", err)
   }
 
-  fmt.Println("Writing credentials to current context")
+  return auth, response.StatusCode, nil
+}
 
-  err = config.SaveToken(username, auth.Access_token)
-  if err != nil {
-    fmt.Print("Failed to write credentials to file.")
-    log.Fatal(err)
+// saveCredentials writes the tokens of an SSO response to the current context,
+// keeping the previous refresh token if the response did not rotate it
+func saveCredentials(username string, auth *AuthResponse, previousRefreshToken string) error {
+  refreshToken := auth.Refresh_token
+  if refreshToken == "" {
+    refreshToken = previousRefreshToken
+  }
+
+  var expiresAt int64
+  if auth.Expires_in > 0 {
+    expiresAt = time.Now().Unix() + auth.Expires_in
+  } else if claims, err := utils.DecodeToken(auth.Access_token); err == nil {
+    expiresAt = claims.ExpiresAt
   }
 
-  fmt.Println("Successfully wrote credentials to", utils.GetConfigPath())
+  return config.SaveToken(username, auth.Access_token, refreshToken, expiresAt)
 }
 
 func init() {
 	RootCmd.AddCommand(loginCmd)
 	loginCmd.Flags().StringVarP(&username, "username", "u", "", "Apigee org admin username")
   loginCmd.Flags().StringVarP(&password, "password", "p", "", "Apigee org admin password")
+  loginCmd.Flags().StringVar(&mfa, "mfa", "", "MFA token, or place in environment as APIGEE_MFA")
+  loginCmd.Flags().BoolVar(&usePasscode, "passcode", false, "Login with a one-time SSO passcode, read from APIGEE_PASSCODE or prompted for")
+  loginCmd.Flags().StringVar(&loginClientID, "client-id", "", "OAuth client id of a service account, or place in environment as APIGEE_CLIENT_ID")
+  loginCmd.Flags().StringVar(&loginClientSecret, "client-secret", "", "OAuth client secret of a service account, or place in environment as APIGEE_CLIENT_SECRET")
+  withDryRun[loginCmd] = true
+}
+
+// requireClientCredentials picks up service account credentials, reporting
+// whether login should use the client_credentials grant
+func requireClientCredentials() (bool, error) {
+  if loginClientID == "" {
+    loginClientID = os.Getenv("APIGEE_CLIENT_ID")
+  }
+
+  if loginClientSecret == "" {
+    loginClientSecret = os.Getenv("APIGEE_CLIENT_SECRET")
+  }
+
+  if loginClientID != "" && loginClientSecret == "" {
+    return false, newError(exitUsage, "Missing required client secret for client '%s'. Use --client-secret or APIGEE_CLIENT_SECRET.", loginClientID)
+  }
+
+  return loginClientID != "", nil
 }
 
-func requireUsername() {
+func requirePasscode() error {
+  if passcode = os.Getenv("APIGEE_PASSCODE"); passcode == "" {
+    if !isInteractive() {
+      return newError(exitUsage, "Missing required passcode. Place it in the environment as APIGEE_PASSCODE.")
+    }
+
+    consolereader := bufio.NewReader(os.Stdin)
+    fmt.Println("Get a one-time passcode at " + sso_target + "/passcode and enter it here:")
+
+    input, err := consolereader.ReadString('\n')
+    if err != nil {
+      return err
+    }
+
+    passcode = strings.TrimSpace(input)
+  }
+
+  return nil
+}
+
+// canLoginUnattended reports whether Login has everything it needs without prompting
+func canLoginUnattended() bool {
+  switch {
+  case loginClientID != "" || os.Getenv("APIGEE_CLIENT_ID") != "":
+    return true
+  case usePasscode:
+    return os.Getenv("APIGEE_PASSCODE") != ""
+  default:
+    return (username != "" || os.Getenv("APIGEE_USERNAME") != "") &&
+      (password != "" || os.Getenv("APIGEE_PASSWORD") != "")
+  }
+}
+
+func requireUsername() error {
   if username == "" {
     if username = os.Getenv("APIGEE_USERNAME"); username == "" {
+      if !isInteractive() {
+        return newError(exitUsage, "Missing required username. Use --username or place in environment as APIGEE_USERNAME.")
+      }
+
       consolereader := bufio.NewReader(os.Stdin)
       fmt.Println("Enter your Apigee username:")
 
       usr, err := consolereader.ReadString('\n')
       if err != nil {
-        fmt.Print(err)
-        os.Exit(1)
+        return err
       }
 
       username = strings.TrimSpace(usr)
     }
   }
+
+  return nil
 }
 
-func requirePassword() {
+func requirePassword() error {
   if password == "" {
     if password = os.Getenv("APIGEE_PASSWORD"); password == "" {
+      if !isInteractive() {
+        return newError(exitUsage, "Missing required password. Use --password or place in environment as APIGEE_PASSWORD.")
+      }
+
       fmt.Println("Enter password for username '" + username + "':")
       pass, err := gopass.GetPasswd()
       if err != nil {
-        fmt.Println(err)
-        os.Exit(1)
+        return err
       }
 
       password = string(pass)
     }
   }
+
+  return nil
 }
 
-func askForMFA() {
+func askForMFA() error {
+  if mfa != "" {
+    return nil
+  }
+
+  // never wait on stdin when there is no one to type the token
+  if mfa = os.Getenv("APIGEE_MFA"); mfa != "" || !isInteractive() {
+    return nil
+  }
+
   consolereader := bufio.NewReader(os.Stdin)
   fmt.Println("Enter your MFA token or just press 'enter' to skip:")
 
   input, err := consolereader.ReadString('\n')
   if err != nil {
-    fmt.Print(err)
-    os.Exit(1)
+    return err
   }
 
   mfa = strings.TrimSpace(input)
+  return nil
 }
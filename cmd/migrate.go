@@ -0,0 +1,255 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var fromContext string
+var toContext string
+var migrateEnvName string
+var keepSourceImages bool
+var noRollback bool
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <environmentName>",
+	Short: "moves an environment and its deployments to another cluster",
+	Long: `Given the name of an active environment, this reads the environment and
+all of its deployments from the cluster of one context and recreates them on the
+cluster of another.
+
+Deployments whose PTS URL refers to an image built on the source cluster are
+re-pointed at the same image revision on the target cluster. The migration stops
+before changing anything if such an image has not been built on the target
+cluster yet, unless --keep-source-images is given, in which case those
+deployments keep pulling from the source cluster.
+
+Deployments that already exist on the target cluster fail the migration with
+the conflict status, unless --overwrite is given. Should any step fail,
+everything created on the target cluster is removed again and the deployments
+patched with --overwrite are patched back, unless --no-rollback is given.
+
+The environment name defaults to the one of the --from-context context.
+
+Example of use:
+$ shipyardctl migrate org1:env1 --from-context e2e --to-context prod
+
+$ shipyardctl migrate org1:env1 --from-context e2e --to-context prod --env org1:env2`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if fromContext == "" || toContext == "" {
+			return usageError(cmd, "Missing required flags '--from-context' and '--to-context'")
+		}
+
+		// read everything from the source cluster first, whose context has the default environment
+		err := useContext(fromContext)
+		if err != nil {
+			return err
+		}
+
+		args = withDefaultEnvironment(args)
+		if len(args) == 0 {
+			return usageError(cmd, "Missing required arg <environmentName>")
+		}
+
+		envName = args[0]
+		targetEnv := envName
+		if migrateEnvName != "" {
+			targetEnv = migrateEnvName
+		}
+
+		sourceCluster := clusterTarget
+		fmt.Printf("Reading %s from %s (%s)\n", envName, fromContext, sourceCluster)
+
+		snap, err := snapshotEnvironment(envName)
+		if err != nil {
+			return err
+		}
+
+		env, deployments, err := snap.decode()
+		if err != nil {
+			return err
+		}
+
+		err = useContext(toContext)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Planning migration to %s on %s (%s)\n", targetEnv, toContext, clusterTarget)
+
+		report := []reportEntry{}
+		unresolved := false
+		for i, dep := range deployments {
+			ref, ok := parsePtsUrl(dep.PtsUrl)
+			if !ok || !sameHost(dep.PtsUrl, sourceCluster) {
+				continue
+			}
+
+			_, status, err := fetchAuthorized(ref.Path())
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case status == 200:
+				deployments[i].PtsUrl = repointUrl(dep.PtsUrl, clusterTarget)
+				report = append(report, reportEntry{"image " + ref.String(), "re-pointed", "used by " + dep.DeploymentName})
+			case keepSourceImages:
+				report = append(report, reportEntry{"image " + ref.String(), "kept", "still pulled from " + sourceCluster})
+			default:
+				report = append(report, reportEntry{"image " + ref.String(), "missing", "build it on " + clusterTarget + " with 'shipyardctl create image'"})
+				unresolved = true
+			}
+		}
+
+		if unresolved {
+			printReport(report)
+			return fmt.Errorf("Migration aborted, nothing was changed.")
+		}
+
+		result, err := restoreEnvironment(env, deployments, targetEnv)
+		report = append(report, result.Report...)
+
+		incomplete := err != nil || result.Failed || len(result.Conflicts) > 0
+		if incomplete && !noRollback {
+			report = append(report, rollbackRestore(targetEnv, result)...)
+		}
+
+		printReport(report)
+
+		if err != nil {
+			return err
+		} else if result.Failed {
+			return fmt.Errorf("Migration failed.")
+		} else if len(result.Conflicts) > 0 {
+			return newError(exitConflict, "Migration failed, %d deployment(s) already exist on %s. Use --overwrite to patch them.", len(result.Conflicts), toContext)
+		}
+
+		fmt.Printf("\nMigration of %s from %s to %s was successful\n", envName, fromContext, toContext)
+		return nil
+	},
+}
+
+// rollbackRestore undoes what restoreEnvironment changed on the current cluster target:
+// it patches back the deployments it patched and removes whatever it created
+func rollbackRestore(targetEnv string, result restoreResult) []reportEntry {
+	defer keepResponsesQuiet()()
+
+	report := []reportEntry{}
+	for _, previous := range result.PatchedDeployments {
+		dep := previous
+		if dep.EnvVars == nil {
+			dep.EnvVars = []EnvVar{} // an empty list drops the variables the patch added, null would keep them
+		}
+
+		js, err := json.Marshal(map[string]interface{}{
+			"publicHosts": dep.PublicHosts,
+			"privateHosts": dep.PrivateHosts,
+			"replicas": dep.Replicas,
+			"ptsUrl": dep.PtsUrl,
+			"envVars": dep.EnvVars,
+		})
+		if err != nil {
+			report = append(report, reportEntry{"deployment " + dep.DeploymentName, "failed", err.Error()})
+			continue
+		}
+
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return patchDeployment(targetEnv, dep.DeploymentName, string(js))
+		})
+
+		if err = checkStatus(status, err, "rollback failed"); err == nil {
+			report = append(report, reportEntry{"deployment " + dep.DeploymentName, "rolled back", "patched back to " + dep.PtsUrl})
+		} else {
+			report = append(report, reportEntry{"deployment " + dep.DeploymentName, "failed", err.Error()})
+		}
+	}
+
+	for _, name := range result.CreatedDeployments {
+		depName := name
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return deleteDeployment(targetEnv, depName)
+		})
+
+		if err = checkStatus(status, err, "rollback failed"); err == nil {
+			report = append(report, reportEntry{"deployment " + depName, "rolled back", "deleted"})
+		} else {
+			report = append(report, reportEntry{"deployment " + depName, "failed", err.Error()})
+		}
+	}
+
+	if result.CreatedEnvironment {
+		status, err := retryIfUnauthorized(func() (int, error) {
+			return deleteEnv(targetEnv)
+		})
+
+		if err = checkStatus(status, err, "rollback failed"); err == nil {
+			report = append(report, reportEntry{"environment " + targetEnv, "rolled back", "deleted"})
+		} else {
+			report = append(report, reportEntry{"environment " + targetEnv, "failed", err.Error()})
+		}
+	}
+
+	return report
+}
+
+// sameHost reports whether the URL is served by the given cluster target
+func sameHost(rawUrl string, cluster string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+
+	c, err := url.Parse(cluster)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, c.Host)
+}
+
+// repointUrl swaps the scheme and host of the URL for those of the given cluster target
+func repointUrl(rawUrl string, cluster string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	c, err := url.Parse(cluster)
+	if err != nil {
+		return rawUrl
+	}
+
+	u.Scheme = c.Scheme
+	u.Host = c.Host
+	return u.String()
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().StringVar(&fromContext, "from-context", "", "Name of the context to migrate from")
+	migrateCmd.Flags().StringVar(&toContext, "to-context", "", "Name of the context to migrate to")
+	migrateCmd.Flags().StringVar(&migrateEnvName, "env", "", "Name of the environment on the target cluster, defaults to the original name")
+	migrateCmd.Flags().BoolVar(&keepSourceImages, "keep-source-images", false, "Keep pulling images that are missing on the target cluster from the source cluster")
+	migrateCmd.Flags().BoolVar(&noRollback, "no-rollback", false, "Leave whatever was created or patched in place when the migration fails")
+	migrateCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Patch deployments that already exist on the target cluster instead of skipping them")
+}
@@ -0,0 +1,73 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/30x/shipyardctl/fake"
+)
+
+func TestRollbackRestore(t *testing.T) {
+	shipyard, cleanup := useFakeCluster(t)
+	defer cleanup()
+
+	overwrite = true
+	defer func() { overwrite = false }()
+
+	shipyard.AddEnvironment(fake.Environment{EnvironmentName: "org1:env1"})
+	shipyard.AddDeployment("org1:env1", fake.Deployment{DeploymentName: "dep1", Replicas: 1, PtsURL: "https://pts.example.com/old"})
+
+	deployments := []Deployment{
+		{DeploymentName: "dep1", Replicas: 2, PtsUrl: "https://pts.example.com/new", EnvVars: []EnvVar{{"ADDED", "1"}}},
+		{DeploymentName: "dep2", Replicas: 1, PtsUrl: "https://pts.example.com/2"},
+	}
+
+	result, err := restoreEnvironment(Environment{EnvironmentName: "org1:env1"}, deployments, "org1:env1")
+	if err != nil || result.Failed || len(result.PatchedDeployments) != 1 {
+		t.Fatalf("restoreEnvironment gave %+v: %v", result, err)
+	}
+
+	for _, entry := range rollbackRestore("org1:env1", result) {
+		if entry.Result != "rolled back" {
+			t.Errorf("rollback of %s: %s %s", entry.Resource, entry.Result, entry.Detail)
+		}
+	}
+
+	dep, _ := shipyard.GetDeployment("org1:env1", "dep1")
+	if dep.PtsURL != "https://pts.example.com/old" || dep.Replicas != 1 || len(dep.EnvVars) != 0 {
+		t.Errorf("dep1 was rolled back to %+v", dep)
+	}
+
+	if _, ok := shipyard.GetDeployment("org1:env1", "dep2"); ok {
+		t.Errorf("the created dep2 was not deleted")
+	}
+
+	if _, ok := shipyard.GetEnvironment("org1:env1"); !ok {
+		t.Errorf("the existing environment was deleted")
+	}
+}
+
+func TestRepointUrl(t *testing.T) {
+	pts := "https://e2e.example.com/imagespaces/org1/images/app/1/pts?x=y"
+
+	if !sameHost(pts, "https://E2E.example.com") || sameHost(pts, "https://prod.example.com") {
+		t.Errorf("sameHost told the clusters apart wrongly")
+	}
+
+	if repointed := repointUrl(pts, "http://prod.example.com:8080"); repointed != "http://prod.example.com:8080/imagespaces/org1/images/app/1/pts?x=y" {
+		t.Errorf("repointUrl gave %s", repointed)
+	}
+}
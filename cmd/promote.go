@@ -0,0 +1,277 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var promoteAs string
+var hostRewrites []string
+
+// promoteCmd represents the promote command
+var promoteCmd = &cobra.Command{
+	Use:   "promote <sourceEnvironment>/<deploymentName> <targetEnvironment>",
+	Short: "copies a deployment from one environment to another",
+	Long: `Given an active deployment and a target environment, this will create the
+same deployment in the target environment, or patch it if it is already there.
+
+The PTS URL and environment variables of the source deployment are carried over.
+Public and private hosts are remapped with the host rewrite rules given by
+--rewrite-host "from=to", or by SHIPYARD_HOST_REWRITE as a comma separated list.
+Environment variables given with --env override those of the source deployment.
+
+The changes are shown and must be confirmed, unless --yes is given.
+
+Example of use:
+$ shipyardctl promote org1:test/dep1 org1:prod --rewrite-host "-test.=-prod."
+
+$ shipyardctl promote org1:test/dep1 org1:prod --as dep2 -e "LOG_LEVEL=warn" --yes`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+
+		if len(args) < 2 {
+			return usageError(cmd, "Missing required args")
+		}
+
+		sep := strings.LastIndex(args[0], "/")
+		if sep <= 0 || sep == len(args[0]) - 1 {
+			return usageError(cmd, "Source must be of the form <environmentName>/<deploymentName>")
+		}
+
+		srcEnv := args[0][:sep]
+		srcDep := args[0][sep+1:]
+		dstEnv := args[1]
+		dstDep := srcDep
+		if promoteAs != "" {
+			dstDep = promoteAs
+		}
+
+		rules, err := parseHostRewrites()
+		if err != nil {
+			return usageError(cmd, "%v", err)
+		}
+
+		overrides, err := parseEnvVars()
+		if err != nil {
+			return usageError(cmd, "%v", err)
+		}
+
+		var source *Deployment
+		status, err := retryIfUnauthorized(func() (status int, err error) {
+			source, status, err = fetchDeployment(srcEnv, srcDep)
+			return
+		})
+
+		if err = resourceError(status, err, "retrieve", "deployment", srcDep, srcEnv); err != nil {
+			return err
+		}
+
+		var current *Deployment
+		status, err = retryIfUnauthorized(func() (status int, err error) {
+			current, status, err = fetchDeployment(dstEnv, dstDep)
+			return
+		})
+
+		if err != nil {
+			return err
+		} else if status != 200 && status != 404 {
+			return statusError(status, "Unable to retrieve deployment %s in %s", dstDep, dstEnv)
+		}
+
+		desired := Deployment{
+			DeploymentName: dstDep,
+			PublicHosts: rewriteHosts(source.PublicHosts, rules),
+			PrivateHosts: rewriteHosts(source.PrivateHosts, rules),
+			Replicas: source.Replicas,
+			PtsUrl: source.PtsUrl,
+			EnvVars: mergeEnvVars(source.EnvVars, overrides),
+		}
+
+		if current == nil {
+			fmt.Printf("Deployment %s will be created in %s:\n", dstDep, dstEnv)
+		} else {
+			desired.Replicas = current.Replicas // leave scaling of the target alone
+			fmt.Printf("Deployment %s in %s will be patched:\n", dstDep, dstEnv)
+		}
+
+		if !printDeploymentDiff(current, desired) {
+			fmt.Println("Nothing to promote, the deployments already match.")
+			return nil
+		}
+
+		if !assumeYes && !askYesNo("Continue?") {
+			return fmt.Errorf("Promotion cancelled.")
+		}
+
+		// the diff above reports the change, without the secrets the response holds
+		defer keepResponsesQuiet()()
+
+		if current == nil {
+			status, err = retryIfUnauthorized(func() (int, error) {
+				return createDeployment(dstEnv, dstDep, desired.PublicHosts, desired.PrivateHosts, desired.Replicas, desired.PtsUrl, desired.EnvVars)
+			})
+
+			return resourceError(status, err, "create", "deployment", dstDep, dstEnv)
+		}
+
+		js, err := json.Marshal(DeploymentPatch{desired.PublicHosts, desired.PrivateHosts, 0, desired.PtsUrl, desired.EnvVars})
+		if err != nil {
+			return err
+		}
+
+		status, err = retryIfUnauthorized(func() (int, error) {
+			return patchDeployment(dstEnv, dstDep, string(js))
+		})
+
+		return resourceError(status, err, "patch", "deployment", dstDep, dstEnv)
+	},
+}
+
+type hostRewrite struct {
+	from string
+	to string
+}
+
+// parseHostRewrites reads the host rewrite rules from --rewrite-host,
+// or from SHIPYARD_HOST_REWRITE when the flag is not given
+func parseHostRewrites() ([]hostRewrite, error) {
+	raw := hostRewrites
+	if len(raw) == 0 {
+		if env := os.Getenv("SHIPYARD_HOST_REWRITE"); env != "" {
+			raw = strings.Split(env, ",")
+		}
+	}
+
+	rules := []hostRewrite{}
+	for _, rule := range raw {
+		split := strings.SplitN(rule, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("Invalid host rewrite rule %q, expected \"from=to\"", rule)
+		}
+
+		rules = append(rules, hostRewrite{split[0], split[1]})
+	}
+
+	return rules, nil
+}
+
+// rewriteHosts applies every rewrite rule to each host of a space separated host list
+func rewriteHosts(hosts string, rules []hostRewrite) string {
+	rewritten := strings.Fields(hosts)
+	for i := range rewritten {
+		for _, rule := range rules {
+			rewritten[i] = strings.Replace(rewritten[i], rule.from, rule.to, -1)
+		}
+	}
+
+	return strings.Join(rewritten, " ")
+}
+
+// mergeEnvVars returns base with every override applied, replacing
+// variables of the same name and appending new ones
+func mergeEnvVars(base []EnvVar, overrides []EnvVar) []EnvVar {
+	merged := append([]EnvVar{}, base...)
+	for _, override := range overrides {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == override.Name {
+				merged[i].Value = override.Value
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			merged = append(merged, override)
+		}
+	}
+
+	return merged
+}
+
+// printDeploymentDiff prints the fields of desired that differ from current, and
+// the environment variables of current it drops marked with -, a nil current
+// meaning the deployment does not exist yet. The values of secret environment
+// variables are redacted. It reports whether there was any difference at all.
+func printDeploymentDiff(current *Deployment, desired Deployment) bool {
+	if current == nil {
+		current = &Deployment{}
+	}
+
+	changed := false
+	diff := func(field string, from string, to string) {
+		if from != to {
+			fmt.Printf("\t%s: %q -> %q\n", field, from, to)
+			changed = true
+		}
+	}
+
+	diff("publicHosts", current.PublicHosts, desired.PublicHosts)
+	diff("privateHosts", current.PrivateHosts, desired.PrivateHosts)
+	diff("replicas", strconv.FormatInt(current.Replicas, 10), strconv.FormatInt(desired.Replicas, 10))
+	diff("ptsUrl", current.PtsUrl, desired.PtsUrl)
+
+	values := map[string]string{}
+	for _, env := range current.EnvVars {
+		values[env.Name] = env.Value
+	}
+
+	kept := map[string]bool{}
+	for _, env := range desired.EnvVars {
+		kept[env.Name] = true
+		if old, ok := values[env.Name]; !ok {
+			fmt.Printf("\tenv %s: (unset) -> %s\n", env.Name, envValue(env.Name, env.Value))
+			changed = true
+		} else if old != env.Value {
+			fmt.Printf("\tenv %s: %s -> %s\n", env.Name, envValue(env.Name, old), envValue(env.Name, env.Value))
+			changed = true
+		}
+	}
+
+	// a patch with environment variables replaces them, dropping those it leaves out
+	for _, env := range current.EnvVars {
+		if len(desired.EnvVars) > 0 && !kept[env.Name] {
+			fmt.Printf("\t- env %s: %s -> (unset)\n", env.Name, envValue(env.Name, env.Value))
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// envValue the quoted value of an environment variable, or REDACTED if it is a secret
+func envValue(name string, value string) string {
+	if isSecret(name) {
+		return redacted
+	}
+
+	return strconv.Quote(value)
+}
+
+func init() {
+	RootCmd.AddCommand(promoteCmd)
+	promoteCmd.Flags().StringVar(&promoteAs, "as", "", "Name of the deployment in the target environment, defaults to the source name")
+	promoteCmd.Flags().StringSliceVarP(&hostRewrites, "rewrite-host", "r", []string{}, "Host rewrite rule \"from=to\" applied to public and private hosts")
+	promoteCmd.Flags().StringSliceVarP(&envVars, "env", "e", []string{}, "Environment variables to override in the target deployment")
+	promoteCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Skip the interactive confirmation")
+}
@@ -0,0 +1,130 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvVars(t *testing.T) {
+	envVars = []string{"A=b", "URL=http://x?a=b", "EMPTY="}
+	defer func() { envVars = []string{} }()
+
+	parsed, err := parseEnvVars()
+	if err != nil {
+		t.Fatalf("parseEnvVars: %v", err)
+	}
+
+	expected := []EnvVar{{"A", "b"}, {"URL", "http://x?a=b"}, {"EMPTY", ""}}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("parseEnvVars gave %v, expected %v", parsed, expected)
+	}
+}
+
+func TestParseEnvVarsRejectsMissingValues(t *testing.T) {
+	defer func() { envVars = []string{} }()
+
+	for _, env := range []string{"BROKEN", "=value"} {
+		envVars = []string{env}
+		if _, err := parseEnvVars(); err == nil {
+			t.Errorf("parseEnvVars accepted %q", env)
+		}
+	}
+}
+
+func TestRewriteHosts(t *testing.T) {
+	rules := []hostRewrite{{"-test.", "-prod."}, {"internal", "private"}}
+
+	rewritten := rewriteHosts("org1-test.example.com  api-test.internal", rules)
+	if rewritten != "org1-prod.example.com api-prod.private" {
+		t.Errorf("rewriteHosts gave %q", rewritten)
+	}
+
+	if rewritten = rewriteHosts("", rules); rewritten != "" {
+		t.Errorf("rewriteHosts of no hosts gave %q", rewritten)
+	}
+}
+
+func TestMergeEnvVars(t *testing.T) {
+	base := []EnvVar{{"A", "1"}, {"B", "2"}}
+
+	merged := mergeEnvVars(base, []EnvVar{{"B", "3"}, {"C", "4"}})
+	expected := []EnvVar{{"A", "1"}, {"B", "3"}, {"C", "4"}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("mergeEnvVars gave %v, expected %v", merged, expected)
+	}
+
+	if base[1].Value != "2" {
+		t.Errorf("mergeEnvVars changed its base to %v", base)
+	}
+}
+
+func TestPrintDeploymentDiffRedactsSecrets(t *testing.T) {
+	current := &Deployment{EnvVars: []EnvVar{{"DB_PASSWORD", "old-pw"}, {"API_KEY", "key1"}, {"LOG_LEVEL", "info"}}}
+	desired := Deployment{EnvVars: []EnvVar{{"DB_PASSWORD", "new-pw"}, {"LOG_LEVEL", "warn"}, {"TOKEN", "tk"}}}
+
+	var changed bool
+	output := captureStdout(t, func() {
+		changed = printDeploymentDiff(current, desired)
+	})
+
+	if !changed {
+		t.Errorf("printDeploymentDiff found no change")
+	}
+
+	for _, secret := range []string{"old-pw", "new-pw", "key1", "tk"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("printDeploymentDiff printed the secret %q:\n%s", secret, output)
+		}
+	}
+
+	for _, line := range []string{
+		"env DB_PASSWORD: REDACTED -> REDACTED",
+		"env LOG_LEVEL: \"info\" -> \"warn\"",
+		"env TOKEN: (unset) -> REDACTED",
+		"- env API_KEY: REDACTED -> (unset)",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("printDeploymentDiff did not print %q:\n%s", line, output)
+		}
+	}
+}
+
+// captureStdout what the function prints to stdout
+func captureStdout(t *testing.T, print func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- data
+	}()
+
+	print()
+	writer.Close()
+
+	return string(<-output)
+}
@@ -0,0 +1,202 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// what secrets are replaced with
+const redacted = "REDACTED"
+
+// words marking a header, field or environment variable as secret
+var secretWords = []string{"password", "passwd", "secret", "token", "credential", "passcode", "mfa", "authorization", "cookie"}
+
+// isSecret reports whether a header, field or environment variable of the given
+// name holds a secret, e.g. client_secret, DB_PASSWORD or API_KEY
+func isSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(name, "key")
+}
+
+// redactHeader a copy of the header without credentials. The scheme of the
+// Authorization header is kept, e.g. "Bearer REDACTED".
+func redactHeader(header http.Header) http.Header {
+	copied := http.Header{}
+	for name, values := range header {
+		if !isSecret(name) {
+			copied[name] = values
+			continue
+		}
+
+		for _, value := range values {
+			if scheme := strings.SplitN(value, " ", 2); len(scheme) == 2 && strings.HasSuffix(strings.ToLower(name), "authorization") {
+				copied[name] = append(copied[name], scheme[0] + " " + redacted)
+			} else {
+				copied[name] = append(copied[name], redacted)
+			}
+		}
+	}
+
+	return copied
+}
+
+// redactURL the URL without secret query parameters, e.g. the mfa_token of a login
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	changed := false
+	for name := range query {
+		if isSecret(name) {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return u.String()
+	}
+
+	copied := *u
+	copied.RawQuery = query.Encode()
+	return copied.String()
+}
+
+// redactBody the body as text without secrets: JSON and form fields with secret names,
+// the values of secret environment variables, and for multipart forms only the fields,
+// leaving out the files
+func redactBody(contentType string, body []byte) string {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for name := range values {
+				if isSecret(name) {
+					values.Set(name, redacted)
+				}
+			}
+
+			return values.Encode()
+		}
+	case mediaType == "multipart/form-data":
+		if fields, err := redactMultipart(body, params["boundary"]); err == nil {
+			return strings.Join(fields, " ")
+		}
+	case strings.Contains(mediaType, "json") || mediaType == "":
+		var value interface{}
+		if json.Unmarshal(body, &value) == nil {
+			if redactedJSON, err := json.Marshal(redactJSON(value)); err == nil {
+				return string(redactedJSON)
+			}
+		}
+	}
+
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("(%d bytes of binary data)", len(body))
+	}
+
+	return string(body)
+}
+
+// redactJSON replaces the values of secret fields, and of the value field of
+// name/value pairs with a secret name such as the environment variables of a deployment
+func redactJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		secretPair := false
+		for key, field := range value {
+			if name, ok := field.(string); ok && strings.EqualFold(key, "name") && isSecret(name) {
+				secretPair = true
+			}
+		}
+
+		for key, field := range value {
+			if _, isString := field.(string); isString && (isSecret(key) || secretPair && strings.EqualFold(key, "value")) {
+				value[key] = redacted
+			} else {
+				value[key] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for ndx, item := range value {
+			value[ndx] = redactJSON(item)
+		}
+	}
+
+	return value
+}
+
+// redactMultipart lists the fields of a multipart form, e.g. envVar=KEY=VAL, with the
+// values of secret environment variables redacted, and the files with their size
+func redactMultipart(body []byte, boundary string) ([]string, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("no boundary")
+	}
+
+	fields := []string{}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, err
+		}
+
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName() != "" {
+			fields = append(fields, fmt.Sprintf("%s=@%s (%d bytes)", part.FormName(), part.FileName(), len(content)))
+		} else {
+			fields = append(fields, part.FormName() + "=" + redactAssignment(part.FormName(), string(content)))
+		}
+	}
+
+	return fields, nil
+}
+
+// redactAssignment redacts a field value, or the value of a KEY=VAL environment variable
+func redactAssignment(field string, value string) string {
+	if isSecret(field) {
+		return redacted
+	}
+
+	if kv := strings.SplitN(value, "=", 2); len(kv) == 2 && isSecret(kv[0]) {
+		return kv[0] + "=" + redacted
+	}
+
+	return value
+}
@@ -0,0 +1,88 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	var value interface{}
+	err := json.Unmarshal([]byte(`{
+		"DeploymentName": "dep1",
+		"client_secret": "s3cret",
+		"Replicas": 2,
+		"EnvVars": [
+			{"Name": "DB_PASSWORD", "Value": "pw"},
+			{"Name": "API_KEY", "Value": "key"},
+			{"Name": "PORT", "Value": "9000"}
+		],
+		"nested": {"refresh_token": "rt", "token_count": 3}
+	}`), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"DeploymentName": "dep1",
+		"client_secret":  redacted,
+		"Replicas":       2.0,
+		"EnvVars": []interface{}{
+			map[string]interface{}{"Name": "DB_PASSWORD", "Value": redacted},
+			map[string]interface{}{"Name": "API_KEY", "Value": redacted},
+			map[string]interface{}{"Name": "PORT", "Value": "9000"},
+		},
+		"nested": map[string]interface{}{"refresh_token": redacted, "token_count": 3.0},
+	}
+
+	if got := redactJSON(value); !reflect.DeepEqual(got, expected) {
+		t.Errorf("redactJSON gave %v, expected %v", got, expected)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	form := "grant_type=password&password=pw&username=me"
+	if got := redactBody("application/x-www-form-urlencoded", []byte(form)); got != "grant_type=password&password=REDACTED&username=me" {
+		t.Errorf("redactBody of a login form gave %s", got)
+	}
+
+	if got := redactBody("application/json", []byte(`{"token":"abc"}`)); got != `{"token":"REDACTED"}` {
+		t.Errorf("redactBody of JSON gave %s", got)
+	}
+}
+
+func TestRedactURLAndHeader(t *testing.T) {
+	u, _ := url.Parse("https://login.example.com/oauth/token?mfa_token=123456&scope=openid")
+	if got := redactURL(u); got != "https://login.example.com/oauth/token?mfa_token=REDACTED&scope=openid" {
+		t.Errorf("redactURL gave %s", got)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer abc")
+	header.Set("Content-Type", "application/json")
+
+	copied := redactHeader(header)
+	if copied.Get("Authorization") != "Bearer REDACTED" || copied.Get("Content-Type") != "application/json" {
+		t.Errorf("redactHeader gave %v", copied)
+	}
+
+	if header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("redactHeader changed the header it copied")
+	}
+}
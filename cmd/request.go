@@ -0,0 +1,98 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// doRequest sends a request to the Shipyard APIs, authenticated with the auth
+// token unless it carries its own credentials. A failure to reach the API is
+// reported as a network error; any response, whatever its status, is not an error.
+func doRequest(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" && authToken != "" {
+		req.Header.Set("Authorization", "Bearer " + authToken)
+	}
+
+	response, err := sendRequest(req)
+	if err != nil {
+		return nil, networkError(err)
+	}
+
+	return response, nil
+}
+
+// set while a command reports on its API calls itself, e.g. a restore,
+// keeping their response bodies out of its output
+var quietResponses bool
+
+// keepResponsesQuiet leaves the response bodies out of stdout until the returned
+// function is called, e.g. deferred
+func keepResponsesQuiet() func() {
+	previous := quietResponses
+	quietResponses = true
+
+	return func() {
+		quietResponses = previous
+	}
+}
+
+// printResponse copies the body of a successful response to stdout. The body of
+// a failed one is decoded into an APIError instead, and only logged with -v.
+// The body of a 401 is left out, as the call is retried after login.
+// Successful bodies are left out too while responses are kept quiet.
+func printResponse(response *http.Response) error {
+	if response.StatusCode == 401 {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return networkError(err)
+	}
+
+	if !isSuccess(response.StatusCode) {
+		if !logging(logBodies) { // logged with every other body otherwise
+			logV(logInfo, "error response", "status", response.StatusCode, "body", logBody(response.Header.Get("Content-Type"), body))
+		}
+
+		return parseAPIError(response.StatusCode, body)
+	}
+
+	if quietResponses {
+		return nil
+	}
+
+	// keep the body apart from whatever is printed after it
+	if len(body) > 0 && !bytes.HasSuffix(body, []byte("\n")) {
+		body = append(body, '\n')
+	}
+
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+// printSuccess reports a successful change on stderr, keeping stdout for the API response
+func printSuccess(format string, a ...interface{}) {
+	if dryRun { // nothing changed
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, format + "\n", a...)
+}
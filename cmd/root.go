@@ -15,16 +15,21 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
 
 	"github.com/spf13/cobra"
+	"github.com/howeyc/gopass"
 	"github.com/30x/shipyardctl/utils"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-var verbose bool
 
 // global variables used by most commands
 var all bool
@@ -38,6 +43,13 @@ var basePath string
 var pubKey string
 var envVars []string
 var sso_target string
+var tokenFromConfig bool
+var contextName string
+var clusterTargetFlag string
+var ssoTargetFlag string
+
+// how long before its expiry a token is considered expired, to leave time for the call
+const tokenExpiryMargin = time.Minute
 
 var config *utils.Config
 
@@ -48,105 +60,164 @@ var RootCmd = &cobra.Command{
 	Long: `shipyardctl is a CLI wrapper for the Shipyard build and deploy APIs.
 
 Pair this command with any of the available functions for applications, images,
-bundles, environments or deployments.`,
+bundles, environments or deployments.
+
+Commands exit with status 0 on success, 2 on missing or invalid arguments, 3 when
+authentication fails, 4 when the API responds not found, 5 on conflicts, 6 on
+server errors, 7 when the API cannot be reached and 1 on any other failure.`,
+	PersistentPreRunE: loadConfig,
+	SilenceErrors: true, // printed by Execute
+	SilenceUsage: true, // part of usage errors only
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
 	}
 }
 
 func init() {
-	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Print environment variables used and API calls made")
+	RootCmd.PersistentFlags().IntVarP(&verbosity, "verbose", "v", 0, "Log to stderr at the given level, 1-9: 1 the context in use, 2 API calls, 4 (a bare -v) headers, 6 bodies, 9 full bodies")
+	RootCmd.PersistentFlags().Lookup("verbose").NoOptDefVal = strconv.Itoa(logHeaders)
 	RootCmd.PersistentFlags().StringVarP(&authToken, "token", "t", "", "Apigee auth token. Required. Or place in APIGEE_TOKEN.")
+	RootCmd.PersistentFlags().StringVar(&utils.ConfigPath, "config", "", "Config file to use, or several separated by '" + string(os.PathListSeparator) + "'. Or place in SHIPYARDCTL_CONFIG.")
+	RootCmd.PersistentFlags().StringVar(&contextName, "context", "", "Context to use for this command only")
+	RootCmd.PersistentFlags().StringVar(&clusterTargetFlag, "cluster-target", "", "Cluster target to use for this command only. Or place in CLUSTER_TARGET.")
+	RootCmd.PersistentFlags().StringVar(&ssoTargetFlag, "sso-target", "", "SSO target to use for this command only. Or place in SSO_LOGIN_URL.")
+
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError(cmd, "%v", err)
+	})
+
+	utils.PassphrasePrompt = promptPassphrase
+
+	// Enrober API path, appended to clusterTarget before each API call
+	enroberPath = "/environments"
+}
+
+// commands that work without reading the config, e.g. from a read-only container
+var withoutConfig = map[*cobra.Command]bool{}
+
+// commands that run even when the current context does not exist, as they are how it gets fixed
+var withoutCurrentContext = map[*cobra.Command]bool{}
+
+// missingContextError the current context of the config does not exist
+type missingContextError struct {
+	name string
+}
+
+func (e *missingContextError) Error() string {
+	return fmt.Sprintf("The current context %q does not exist. Switch to another one with " +
+		"'shipyardctl config use-context <name>', or check the config with 'shipyardctl config validate'.", e.name)
+}
+
+// loadConfig reads the config for the commands that need it, once the flags
+// pointing at it are parsed
+func loadConfig(cmd *cobra.Command, args []string) error {
+	if err := requireDryRunSupport(cmd); err != nil {
+		return err
+	}
+
+	if withoutConfig[cmd] || cmd.Name() == "help" {
+		return nil
+	}
+
+	err := initConfig()
+	if _, missing := err.(*missingContextError); missing && withoutCurrentContext[cmd] {
+		return nil
+	}
+
+	return err
+}
+
+// initConfig reads the config file, creating it if needed, and resolves the
+// cluster and SSO targets from the flags, the environment and the config.
+// When there is no place to keep a config file, a default one is kept in memory.
+func initConfig() error {
+	// read environment variables or use defaults
+	checkEnvironmentOrDefault()
 
 	// check if there is a config file present
 	check, err := utils.ConfigExists()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+		fmt.Fprintln(os.Stderr, "Unable to locate the config file:", err)
+		return useInMemoryConfig()
 	}
 
-	// read environment variables or use defaults
-	checkEnvironmentOrDefault()
+	// replays leave no trace, not even a new config file
+	if !check && replayFile != "" {
+		config = utils.NewInMemoryConfig("default", sso_target, clusterTarget)
+		return applyConfig()
+	}
 
 	// make a new config file because there wasn't one
 	if !check {
-		fmt.Println("No config file present. Creating one now.")
+		fmt.Fprintln(os.Stderr, "No config file present. Creating one now.")
 
 		err = utils.InitNewConfigFile("default", sso_target, clusterTarget)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(-1)
+			fmt.Fprintln(os.Stderr, "Unable to create the config file:", err)
+			return useInMemoryConfig()
 		}
 
-		fmt.Printf("Created new config file.\n\n")
+		fmt.Fprintf(os.Stderr, "Created new config file.\n\n")
 	}
 
 	// read config into memory
 	config, err = utils.LoadConfig()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+		return err
 	}
 
-	// environment overrides config, so check there first before setting vars based on config
-	checkEnvironmentOrConfig()
+	// replayed tokens are redacted, keep them from replacing the saved ones
+	if replayFile != "" {
+		if err = config.KeepInMemory(); err != nil {
+			return err
+		}
+	}
 
-	// Enrober API path, appended to clusterTarget before each API call
-	enroberPath = "/environments"
+	return applyConfig()
 }
 
-// PrintVerboseRequest used to print the request when using verbose
-func PrintVerboseRequest(req *http.Request) {
-	context := config.GetCurrentContext()
-	fmt.Println("Current context:")
-	if ct := os.Getenv("CLUSTER_TARGET"); ct != "" {
-		fmt.Printf("Cluster: %s (from environment variable)\n", ct)
-	} else {
-		fmt.Printf("Cluster: %s (from config file)\n", context.ClusterInfo.Cluster)
-	}
+// useInMemoryConfig continues with a default config that is not saved
+func useInMemoryConfig() error {
+	fmt.Fprintln(os.Stderr, "Continuing without a config file, logins will not be remembered.")
+	config = utils.NewInMemoryConfig("default", sso_target, clusterTarget)
 
-	if st := os.Getenv("SSO_LOGIN_URL"); st != "" {
-		fmt.Printf("SSO login: %s (from environment variable)\n", st)
-	} else {
-		fmt.Printf("SSO login: %s (from config file)\n", context.ClusterInfo.SSO)
-	}
+	return applyConfig()
+}
 
-	if org := os.Getenv("APIGEE_ORG"); org != "" {
-		fmt.Printf("Apigee org: %s (from environment variable)\n", org)
-	} else if orgName != "" {
-		fmt.Printf("Apigee org: %s (from CLI flag)\n", orgName)
+// applyConfig switches to the context given by --context and resolves the targets,
+// failing when the current context does not exist
+func applyConfig() error {
+	if contextName != "" {
+		err := config.OverrideContext(contextName)
+		if err != nil {
+			return err
+		}
 	}
 
-	if envName != "" {
-		fmt.Printf("Environment name: %s\n", envName)
-	}
+	// environment overrides config, so check there first before setting vars based on config
+	checkEnvironmentOrConfig()
 
-	dump, err := httputil.DumpRequestOut(req, false) // not dump req body
-	if err != nil {
-		fmt.Println("Request dump failed. Request state is unknown. Aborting.")
-		os.Exit(1)
+	if config.GetCurrentContext() == nil {
+		return &missingContextError{config.CurrentContext}
 	}
-	fmt.Println("\nRequest:")
-	fmt.Printf("%s\n", string(dump))
-}
 
-// PrintVerboseResponse used to print the response when using verbose
-func PrintVerboseResponse(res *http.Response) {
-	if res != nil {
-		fmt.Println("\nResponse:")
-		dump, err := httputil.DumpResponse(res, false)
-		if err != nil {
-			fmt.Println("Could not dump response")
-		}
+	return nil
+}
 
-		fmt.Printf("%s", string(dump))
+// requireConfigFile short circuits commands changing the config
+// when it is only kept in memory
+func requireConfigFile() error {
+	if config == nil || config.Path() == "" {
+		return fmt.Errorf("No config file loaded.")
 	}
+
+	return nil
 }
 
 func checkEnvironmentOrDefault() {
@@ -160,11 +231,15 @@ func checkEnvironmentOrDefault() {
 }
 
 func checkEnvironmentOrConfig() {
-	if os.Getenv("CLUSTER_TARGET") == "" {
+	if clusterTargetFlag != "" {
+		clusterTarget = clusterTargetFlag
+	} else if os.Getenv("CLUSTER_TARGET") == "" {
 		clusterTarget = config.GetCurrentClusterTarget()
 	}
 
-	if sso_target = os.Getenv("SSO_LOGIN_URL"); sso_target == "" {
+	if ssoTargetFlag != "" {
+		sso_target = ssoTargetFlag
+	} else if sso_target = os.Getenv("SSO_LOGIN_URL"); sso_target == "" {
 		sso_target = config.GetCurrentSSOTarget()
 	}
 }
@@ -174,54 +249,257 @@ func checkEnvironmentOrConfig() {
 // 2. APIGEE_TOKEN env var
 // 3. config file
 // 4. Runs login sequence if there is no token at all
-func RequireAuthToken() {
+// Should the token be about to expire, the login sequence is run up front.
+func RequireAuthToken() error {
+	tokenFromConfig = false
 	if authToken == "" { // check flag first
 		if authToken = os.Getenv("APIGEE_TOKEN"); authToken == "" { // check environment second
-			if config != nil { // check config file last
-				authToken = config.GetCurrentToken()
+			if config == nil { // check config file last
+				return authError("Missing required auth token. Run shipyardctl login.")
+			}
 
-				if authToken == "" {
-					Login()
-					authToken = config.GetCurrentToken()
+			tokenFromConfig = true
+			if authToken = config.GetCurrentToken(); authToken == "" {
+				if err := renewLogin("You are not logged in."); err != nil {
+					return err
 				}
-
-				return
-			} else {
-				fmt.Println("No config file loaded.")
-				fmt.Println("Missing required auth token.")
-				fmt.Println("Run shipyardctl login.")
-				os.Exit(1)
 			}
 		}
 	}
 
-	return
+	return ensureFreshToken()
 }
 
-// CheckIfAuthn checks if the API call was authenticated or not
-func CheckIfAuthn(status int) bool {
-	if status == 401 {
-		fmt.Println("Your token has expired. Please login again.")
-		username = config.GetCurrentUsername()
-		Login()
+// ensureFreshToken reads the expiry of the auth token and, when it is about to
+// expire, logs in again before the API gets a chance to reject it
+func ensureFreshToken() error {
+	claims, err := utils.DecodeToken(authToken)
+	if err != nil || !claims.ExpiresWithin(tokenExpiryMargin) {
+		return nil // either still fresh or not a JWT, in which case the API decides
+	}
+
+	expiry := fmt.Sprintf("Your token expires at %s.", claims.Expiry().Local().Format(time.RFC1123))
+	if claims.ExpiresWithin(0) {
+		expiry = fmt.Sprintf("Your token expired at %s.", claims.Expiry().Local().Format(time.RFC1123))
+	}
+	if !tokenFromConfig {
+		return authError("%s Provide a fresh token with --token or APIGEE_TOKEN.", expiry)
+	}
+
+	return renewLogin(expiry)
+}
+
+// renewLogin replaces the token of the current context, using its refresh token
+// when there is one and falling back to the interactive login sequence
+func renewLogin(reason string) error {
+	if RefreshLogin() {
 		authToken = config.GetCurrentToken()
-		return false
+		return nil
+	}
+
+	if err := requireInteractiveLogin(reason); err != nil {
+		return err
+	}
+
+	fmt.Println(reason + " Please login again.")
+	username = config.GetCurrentUsername()
+	if err := Login(); err != nil {
+		return err
+	}
+
+	authToken = config.GetCurrentToken()
+	return nil
+}
+
+// requireInteractiveLogin fails fast, giving the reason, when there is no
+// terminal to prompt for credentials on (e.g. in CI)
+func requireInteractiveLogin(reason string) error {
+	if isInteractive() || canLoginUnattended() {
+		return nil
+	}
+
+	return authError("%s\nUnable to prompt for credentials outside of a terminal.\n" +
+		"Run shipyardctl login, provide a token with --token or APIGEE_TOKEN,\n" +
+		"or credentials with APIGEE_USERNAME and APIGEE_PASSWORD or APIGEE_CLIENT_ID and APIGEE_CLIENT_SECRET.", reason)
+}
+
+// promptPassphrase asks for the passphrase of the encrypted credentials file
+func promptPassphrase() ([]byte, error) {
+	if !isInteractive() {
+		return nil, fmt.Errorf("Missing passphrase for the encrypted credentials file. Set SHIPYARDCTL_PASSPHRASE.")
 	}
 
-	return true
+	fmt.Println("Enter the passphrase of your shipyardctl credentials:")
+	return gopass.GetPasswd()
+}
+
+// isInteractive reports whether stdin is a terminal
+func isInteractive() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// useContext points the rest of this invocation at the named context,
+// without changing the current context saved in the config file
+func useContext(name string) error {
+	if config == nil {
+		return fmt.Errorf("No config file loaded.")
+	}
+
+	err := config.OverrideContext(name)
+	if err != nil {
+		return err
+	}
+
+	clusterTarget = config.GetCurrentClusterTarget()
+	sso_target = config.GetCurrentSSOTarget()
+	username = config.GetCurrentUsername()
+
+	tokenFromConfig = true
+	if authToken = config.GetCurrentToken(); authToken == "" {
+		if err = renewLogin("You are not logged in to context " + name + "."); err != nil {
+			return err
+		}
+	}
+
+	return ensureFreshToken()
 }
 
 // RequireOrgName used to short circuit commands
 // requiring the Apigee org name if it is not present
-func RequireOrgName() {
-	if orgName == "" {
-		if orgName = os.Getenv("APIGEE_ORG"); orgName == "" {
-			fmt.Println("Missing required flag '--org', or place in environment as APIGEE_ORG.")
-			os.Exit(1)
-		}
+func RequireOrgName(cmd *cobra.Command) error {
+	if orgName = defaultOrgName(); orgName == "" {
+		return usageError(cmd, "Missing required flag '--org', or place in environment as APIGEE_ORG,\n" +
+			"or set a default with 'shipyardctl config set-context <name> --org <org>'.")
+	}
+
+	return nil
+}
+
+// defaultOrgName resolves the org from the --org flag, APIGEE_ORG or the current context
+func defaultOrgName() string {
+	if orgName != "" {
+		return orgName
+	}
+
+	if org := os.Getenv("APIGEE_ORG"); org != "" {
+		return org
+	}
+
+	if config != nil {
+		return config.GetCurrentOrg()
+	}
+
+	return ""
+}
+
+// defaultEnvironment resolves the environment name from APIGEE_ENVIRONMENT_NAME or
+// the current context, qualifying a bare name with the default org
+func defaultEnvironment() string {
+	env := os.Getenv("APIGEE_ENVIRONMENT_NAME")
+	if env == "" && config != nil {
+		env = config.GetCurrentEnvironment()
+	}
+
+	if env == "" || strings.Contains(env, ":") {
+		return env
+	}
+
+	if org := defaultOrgName(); org != "" {
+		return org + ":" + env
+	}
+
+	return env
+}
+
+// withDefaultEnvironment prepends the default environment name to the arguments
+// when they do not start with one. Environment names are of the form org:env,
+// which tells them apart from the names of deployments and hosts.
+func withDefaultEnvironment(args []string) []string {
+	if len(args) > 0 && strings.Contains(args[0], ":") {
+		return args
+	}
+
+	if env := defaultEnvironment(); env != "" {
+		return append([]string{env}, args...)
+	}
+
+	return args
+}
+
+// fetchResource issues an authenticated GET against the cluster target for the
+// given path and returns the response body instead of dumping it to stdout
+func fetchResource(path string) ([]byte, int, error) {
+	req, err := http.NewRequest("GET", clusterTarget + path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, err := doRequest(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, networkError(err)
+	}
+
+	return body, response.StatusCode, nil
+}
+
+// fetchAuthorized fetches the resource at the given path, logging in again
+// and retrying once should the token be rejected
+func fetchAuthorized(path string) (body []byte, status int, err error) {
+	status, err = retryIfUnauthorized(func() (int, error) {
+		body, status, err = fetchResource(path)
+		return status, err
+	})
+
+	return body, status, err
+}
+
+// retryIfUnauthorized runs an API call and, should the token be rejected,
+// logs in again and retries the call once
+func retryIfUnauthorized(call func() (int, error)) (int, error) {
+	if err := ensureFreshToken(); err != nil {
+		return 0, err
+	}
+
+	status, err := call()
+	if err != nil || status != 401 {
+		return status, err
+	}
+
+	if config == nil {
+		return status, authError("Your token has expired. Please login again.")
+	}
+
+	if err = renewLogin("Your token has expired."); err != nil {
+		return status, err
+	}
+
+	// retry once more
+	status, err = call()
+	if err == nil && status == 401 {
+		return status, authError("Unable to authenticate. Please check your SSO target URL is correct.")
+	}
+
+	return status, err
+}
+
+// askYesNo prints the given question and reports whether the user answered yes
+func askYesNo(question string) bool {
+	consolereader := bufio.NewReader(os.Stdin)
+	fmt.Println(question + " [y/N]")
+
+	input, err := consolereader.ReadString('\n')
+	if err != nil {
+		return false
 	}
 
-	return
+	answer := strings.ToLower(strings.TrimSpace(input))
+	return answer == "y" || answer == "yes"
 }
 
 // MakeBuildPath make build service path with given orgName
@@ -0,0 +1,301 @@
+// Copyright © 2016 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// smokeResult the outcome of checking a single URL
+type smokeResult struct {
+	Url string
+	Passed bool
+	Status int
+	Latency time.Duration
+	Attempts int
+	Detail string
+}
+
+var smoke bool
+var smokePaths []string
+var smokeScheme string
+var expectStatus int
+var bodyRegex string
+var latencyBudget time.Duration
+var smokeRetries int
+var retryInterval time.Duration
+
+// testCmd represents the test command
+var testCmd = &cobra.Command{
+	Use:   "test [command]",
+	Short: "checks the health of a Shipyard artifact",
+	Long: `This command, when paired with the proper subcommand, will check that the
+respective artifact is working as expected.`,
+}
+
+var testDeploymentCmd = &cobra.Command{
+	Use:   "deployment <environmentName> <deploymentName>",
+	Short: "checks that an active deployment answers on its public host",
+	Long: `Given the name of an active deployment, this issues HTTP requests against
+each of its public hosts and fails unless every one of them answers as expected.
+
+The paths requested default to the public path of the deployment's image, and can
+be given with --path instead. A check passes when the response has the expected
+status, its body matches --body-regex if given, and it arrives within the latency
+budget. Failed checks are retried before the deployment is reported unhealthy.
+
+Example of use:
+$ shipyardctl test deployment org1:env1 dep1
+
+$ shipyardctl test deployment org1:env1 dep1 --path /health --body-regex '"ok"' --latency 500ms`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := RequireAuthToken(); err != nil {
+			return err
+		}
+		args = withDefaultEnvironment(args)
+
+		if len(args) < 2 {
+			return usageError(cmd, "Missing required args")
+		}
+
+		envName = args[0]
+		depName = args[1]
+
+		return runSmokeTest(envName, depName)
+	},
+}
+
+// smokeCheck the URLs to check and what their responses must look like
+type smokeCheck struct {
+	urls []string
+	body *regexp.Regexp
+}
+
+// newSmokeCheck the check of the paths given by --path, or the public paths of
+// the deployment's image, on each of the given hosts
+func newSmokeCheck(dep *Deployment, hosts []string) (*smokeCheck, error) {
+	body, err := regexp.Compile(bodyRegex)
+	if err != nil {
+		return nil, newError(exitUsage, "Invalid body regex: %v", err)
+	}
+
+	paths := smokePaths
+	if len(paths) == 0 {
+		paths = imagePublicPaths(dep.PtsUrl)
+	}
+
+	check := &smokeCheck{body: body}
+	for _, host := range hosts {
+		for _, path := range paths {
+			check.urls = append(check.urls, smokeScheme + "://" + host + path)
+		}
+	}
+
+	return check, nil
+}
+
+// run checks every URL, retrying failed checks the given number of times,
+// and reports whether all of them passed
+func (check *smokeCheck) run(retries int) ([]smokeResult, bool) {
+	results := []smokeResult{}
+	healthy := true
+	for _, url := range check.urls {
+		result := checkUrl(url, check.body, retries)
+		results = append(results, result)
+		healthy = healthy && result.Passed
+	}
+
+	return results, healthy
+}
+
+// runSmokeTest checks the public hosts of the deployment and prints a report,
+// failing unless the deployment is healthy
+func runSmokeTest(envName string, depName string) error {
+	var dep *Deployment
+	status, err := retryIfUnauthorized(func() (status int, err error) {
+		dep, status, err = fetchDeployment(envName, depName)
+		return
+	})
+
+	if err = checkStatus(status, err, "Unable to retrieve deployment %s in %s", depName, envName); err != nil {
+		return err
+	}
+
+	hosts := strings.Fields(dep.PublicHosts)
+	if len(hosts) == 0 {
+		return fmt.Errorf("Deployment %s has no public hosts to test.", depName)
+	}
+
+	check, err := newSmokeCheck(dep, hosts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Testing %s in %s...\n", depName, envName)
+
+	results, healthy := check.run(smokeRetries)
+	printSmokeResults(results)
+
+	if !healthy {
+		return fmt.Errorf("%s is unhealthy", depName)
+	}
+
+	fmt.Printf("\n%s is healthy\n", depName)
+	return nil
+}
+
+// printSmokeResults prints a table of the outcome of each check
+func printSmokeResults(results []smokeResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "URL\tRESULT\tSTATUS\tLATENCY\tATTEMPTS\tDETAIL")
+	for _, result := range results {
+		outcome := "pass"
+		if !result.Passed {
+			outcome = "FAIL"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%dms\t%d\t%s\n", result.Url, outcome, result.Status,
+			int64(result.Latency / time.Millisecond), result.Attempts, result.Detail)
+	}
+	w.Flush()
+}
+
+// checkUrl requests the URL until it passes or the retries run out. The requests
+// are recorded and replayed along with the API calls.
+func checkUrl(url string, body *regexp.Regexp, retries int) smokeResult {
+	// give up on the request well after the budget, so slow answers are reported as such
+	client := &http.Client{Timeout: latencyBudget + 10 * time.Second}
+
+	result := smokeResult{Url: url}
+	for result.Attempts = 1; ; result.Attempts++ {
+		var response *http.Response
+		req, err := http.NewRequest("GET", url, nil)
+		start := time.Now()
+		if err == nil {
+			response, err = roundTrip(client, req, nil)
+		}
+		result.Latency = time.Since(start)
+
+		if err != nil {
+			result.Status = 0
+			result.Detail = err.Error()
+		} else {
+			content, err := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			result.Status = response.StatusCode
+
+			switch {
+			case err != nil:
+				result.Detail = err.Error()
+			case response.StatusCode != expectStatus:
+				result.Detail = fmt.Sprintf("expected status %d", expectStatus)
+			case !body.Match(content):
+				result.Detail = fmt.Sprintf("body does not match %q", bodyRegex)
+			case result.Latency > latencyBudget:
+				result.Detail = fmt.Sprintf("slower than %s", latencyBudget)
+			default:
+				result.Passed = true
+				result.Detail = ""
+				return result
+			}
+		}
+
+		if result.Attempts > retries {
+			return result
+		}
+
+		pause(retryInterval)
+	}
+}
+
+// pause waits for the given time, unless replaying, when the recording
+// already holds what came after the wait
+func pause(wait time.Duration) {
+	if replayFile == "" {
+		time.Sleep(wait)
+	}
+}
+
+// imagePublicPaths looks up the public paths of the image the PTS URL refers to,
+// falling back to the root path
+func imagePublicPaths(ptsUrl string) []string {
+	ref, ok := parsePtsUrl(ptsUrl)
+	if !ok {
+		return []string{"/"}
+	}
+
+	content, status, err := fetchAuthorized(ref.Path())
+
+	image := struct {
+		PublicPath string
+	}{}
+
+	if err != nil || status != 200 || json.Unmarshal(content, &image) != nil {
+		return []string{"/"}
+	}
+
+	// public paths are of the form "port:/path", possibly several space separated
+	paths := []string{}
+	for _, publicPath := range strings.Fields(image.PublicPath) {
+		if split := strings.SplitN(publicPath, ":", 2); len(split) == 2 {
+			publicPath = split[1]
+		}
+
+		if !strings.HasPrefix(publicPath, "/") {
+			publicPath = "/" + publicPath
+		}
+
+		paths = append(paths, publicPath)
+	}
+
+	if len(paths) == 0 {
+		return []string{"/"}
+	}
+
+	return paths
+}
+
+// addSmokeTestFlags registers the flags configuring the HTTP checks on the given command
+func addSmokeTestFlags(cmd *cobra.Command) {
+	addCheckFlags(cmd, "public hosts")
+	cmd.Flags().IntVar(&smokeRetries, "retries", 5, "Number of times to retry a failed check")
+	cmd.Flags().DurationVar(&retryInterval, "retry-interval", 5 * time.Second, "Time to wait between retries")
+}
+
+// addCheckFlags registers the flags setting what the HTTP checks request and
+// expect, the checks being made against the given hosts
+func addCheckFlags(cmd *cobra.Command, hosts string) {
+	cmd.Flags().StringSliceVar(&smokePaths, "path", []string{}, "Path to request, defaults to the public path of the image")
+	cmd.Flags().StringVar(&smokeScheme, "scheme", "https", "Scheme used to reach the " + hosts)
+	cmd.Flags().IntVar(&expectStatus, "expect-status", 200, "Expected response status")
+	cmd.Flags().StringVar(&bodyRegex, "body-regex", "", "Regular expression the response body must match")
+	cmd.Flags().DurationVar(&latencyBudget, "latency", 2 * time.Second, "Maximum time to wait for a response")
+}
+
+func init() {
+	RootCmd.AddCommand(testCmd)
+	testCmd.AddCommand(testDeploymentCmd)
+	addSmokeTestFlags(testDeploymentCmd)
+}
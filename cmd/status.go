@@ -19,7 +19,6 @@ import (
 	"io"
 	"fmt"
 	"os"
-	"log"
 
 	"github.com/spf13/cobra"
 )
@@ -33,51 +32,40 @@ var statusCmd = &cobra.Command{
 Example of use:
 
 $ shipyardctl get status`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		// get kiln status
-		kilnReq, err := http.NewRequest("GET", clusterTarget + "/imagespaces/status", nil)
-		if verbose {
-			PrintVerboseRequest(kilnReq)
-		}
-
-		kilnRes, err := http.DefaultClient.Do(kilnReq)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if verbose {
-			PrintVerboseResponse(kilnRes)
-		}
-
-		defer kilnRes.Body.Close()
 		fmt.Print("Build service status: ")
-		_, err = io.Copy(os.Stdout, kilnRes.Body)
-		if err != nil {
-			log.Fatal(err)
+		status, err := getComponentStatus("/imagespaces/status")
+		if err = checkStatus(status, err, "Build service is unavailable"); err != nil {
+			return err
 		}
 
-		enroberReq, err := http.NewRequest("GET", clusterTarget + "/environments/status", nil)
-		if verbose {
-			PrintVerboseRequest(enroberReq)
-		}
+		fmt.Print("\nDeployment service status: ")
+		status, err = getComponentStatus("/environments/status")
+		return checkStatus(status, err, "Deployment service is unavailable")
+	},
+}
 
-		enroberRes, err := http.DefaultClient.Do(enroberReq)
-		if err != nil {
-			log.Fatal(err)
-		}
+// getComponentStatus prints the status reported by a Shipyard component
+func getComponentStatus(path string) (int, error) {
+	req, err := http.NewRequest("GET", clusterTarget + path, nil)
+	if err != nil {
+		return 0, err
+	}
 
-		if verbose {
-			PrintVerboseResponse(enroberRes)
-		}
+	response, err := doRequest(req)
+	if err != nil {
+		return 0, err
+	}
 
-		defer enroberRes.Body.Close()
-		fmt.Print("\nDeployment service status: ")
-		_, err = io.Copy(os.Stdout, enroberRes.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-	},
+	defer response.Body.Close()
+	_, err = io.Copy(os.Stdout, response.Body)
+	if err != nil {
+		return 0, networkError(err)
+	}
+
+	return response.StatusCode, nil
 }
 
 func init() {
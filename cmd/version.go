@@ -18,4 +18,5 @@ var versionCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(versionCmd)
+	withoutConfig[versionCmd] = true
 }
@@ -0,0 +1,294 @@
+package fake
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sort"
+  "strings"
+)
+
+// Environment an Enrober environment, named org:env
+type Environment struct {
+  EnvironmentName string `json:"environmentName"`
+  HostNames []string `json:"hostNames"`
+}
+
+// Deployment an Enrober deployment
+type Deployment struct {
+  DeploymentName string `json:"deploymentName"`
+  PublicHosts string `json:"publicHosts"`
+  PrivateHosts string `json:"privateHosts"`
+  Replicas int64 `json:"replicas"`
+  PtsURL string `json:"ptsUrl"`
+  EnvVars []EnvVar `json:"envVars"`
+}
+
+// EnvVar an environment variable of a deployment
+type EnvVar struct {
+  Name string `json:"name"`
+  Value string `json:"value"`
+}
+
+// deploymentPatch the fields of a deployment a patch may change, nil when left as is
+type deploymentPatch struct {
+  PublicHosts *string
+  PrivateHosts *string
+  Replicas *int64
+  PtsURL *string `json:"ptsUrl"`
+  EnvVars []EnvVar
+}
+
+// AddEnvironment adds an environment, e.g. to set up a test. It reports
+// whether there was none of the name yet.
+func (s *Server) AddEnvironment(env Environment) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  return s.addEnvironment(env)
+}
+
+// AddDeployment adds a deployment to an existing environment, reporting whether
+// the environment exists and had no deployment of the name yet
+func (s *Server) AddDeployment(envName string, dep Deployment) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.environments[envName] == nil || s.deployments[envName][dep.DeploymentName] != nil {
+    return false
+  }
+
+  s.deployments[envName][dep.DeploymentName] = &dep
+  return true
+}
+
+// GetEnvironment the environment of the given name, if any
+func (s *Server) GetEnvironment(envName string) (Environment, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  env, ok := s.environments[envName]
+  if !ok {
+    return Environment{}, false
+  }
+
+  return *env, true
+}
+
+// GetDeployment the deployment of the given name in the environment, if any
+func (s *Server) GetDeployment(envName string, depName string) (Deployment, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  dep, ok := s.deployments[envName][depName]
+  if !ok {
+    return Deployment{}, false
+  }
+
+  return *dep, true
+}
+
+func (s *Server) addEnvironment(env Environment) bool {
+  if s.environments[env.EnvironmentName] != nil {
+    return false
+  }
+
+  if env.HostNames == nil {
+    env.HostNames = []string{}
+  }
+
+  s.environments[env.EnvironmentName] = &env
+  s.deployments[env.EnvironmentName] = map[string]*Deployment{}
+  return true
+}
+
+// serveEnrober the environments API, below /environments
+func (s *Server) serveEnrober(w http.ResponseWriter, r *http.Request, parts []string) {
+  switch {
+  case len(parts) == 0 && r.Method == "POST":
+    s.createEnvironment(w, r)
+  case len(parts) == 1:
+    s.serveEnvironment(w, r, parts[0])
+  case len(parts) >= 2 && parts[1] == "deployments":
+    if s.environments[parts[0]] == nil {
+      writeError(w, http.StatusNotFound, fmt.Sprintf("environment %s not found", parts[0]))
+      return
+    }
+
+    s.serveDeployments(w, r, parts[0], parts[2:])
+  case len(parts) == 0:
+    methodNotAllowed(w, r)
+  default:
+    writeError(w, http.StatusNotFound, "no such API")
+  }
+}
+
+func (s *Server) createEnvironment(w http.ResponseWriter, r *http.Request) {
+  env := Environment{}
+  if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid environment: " + err.Error())
+    return
+  }
+
+  if split := strings.Split(env.EnvironmentName, ":"); len(split) != 2 || split[0] == "" || split[1] == "" {
+    writeError(w, http.StatusBadRequest, "environment name must be of the form org:env")
+    return
+  }
+
+  if !s.addEnvironment(env) {
+    writeError(w, http.StatusConflict, fmt.Sprintf("environment %s already exists", env.EnvironmentName))
+    return
+  }
+
+  writeJSON(w, http.StatusCreated, s.environments[env.EnvironmentName])
+}
+
+func (s *Server) serveEnvironment(w http.ResponseWriter, r *http.Request, envName string) {
+  env := s.environments[envName]
+  if env == nil {
+    writeError(w, http.StatusNotFound, fmt.Sprintf("environment %s not found", envName))
+    return
+  }
+
+  switch r.Method {
+  case "GET":
+    writeJSON(w, http.StatusOK, env)
+  case "PATCH":
+    patch := struct{ HostNames []string }{}
+    if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+      writeError(w, http.StatusBadRequest, "invalid environment patch: " + err.Error())
+      return
+    }
+
+    if patch.HostNames != nil {
+      env.HostNames = patch.HostNames
+    }
+
+    writeJSON(w, http.StatusOK, env)
+  case "DELETE":
+    delete(s.environments, envName)
+    delete(s.deployments, envName)
+    writeJSON(w, http.StatusOK, env)
+  default:
+    methodNotAllowed(w, r)
+  }
+}
+
+// serveDeployments the deployments API of an existing environment
+func (s *Server) serveDeployments(w http.ResponseWriter, r *http.Request, envName string, parts []string) {
+  deployments := s.deployments[envName]
+
+  if len(parts) == 0 {
+    switch r.Method {
+    case "GET":
+      names := []string{}
+      for name := range deployments {
+        names = append(names, name)
+      }
+      sort.Strings(names)
+
+      list := []*Deployment{}
+      for _, name := range names {
+        list = append(list, deployments[name])
+      }
+
+      writeJSON(w, http.StatusOK, list)
+    case "POST":
+      s.createDeployment(w, r, envName)
+    default:
+      methodNotAllowed(w, r)
+    }
+
+    return
+  }
+
+  dep := deployments[parts[0]]
+  if dep == nil {
+    writeError(w, http.StatusNotFound, fmt.Sprintf("deployment %s not found in %s", parts[0], envName))
+    return
+  }
+
+  switch {
+  case len(parts) == 2 && parts[1] == "logs" && r.Method == "GET":
+    w.Header().Set("Content-Type", "text/plain")
+    if r.URL.Query().Get("previous") == "true" {
+      fmt.Fprintf(w, "%s: logs of the previous container\n", dep.DeploymentName)
+    } else {
+      fmt.Fprintf(w, "%s: started with %d replica(s)\n%s: listening\n", dep.DeploymentName, dep.Replicas, dep.DeploymentName)
+    }
+  case len(parts) != 1:
+    writeError(w, http.StatusNotFound, "no such API")
+  case r.Method == "GET":
+    writeJSON(w, http.StatusOK, dep)
+  case r.Method == "PATCH":
+    s.patchDeployment(w, r, dep)
+  case r.Method == "DELETE":
+    delete(deployments, dep.DeploymentName)
+    writeJSON(w, http.StatusOK, dep)
+  default:
+    methodNotAllowed(w, r)
+  }
+}
+
+func (s *Server) createDeployment(w http.ResponseWriter, r *http.Request, envName string) {
+  dep := Deployment{}
+  if err := json.NewDecoder(r.Body).Decode(&dep); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid deployment: " + err.Error())
+    return
+  }
+
+  switch {
+  case dep.DeploymentName == "":
+    writeError(w, http.StatusBadRequest, "deployment name is required")
+    return
+  case dep.PtsURL == "":
+    writeError(w, http.StatusBadRequest, "ptsUrl is required")
+    return
+  case dep.Replicas < 0:
+    writeError(w, http.StatusBadRequest, "replicas must not be negative")
+    return
+  case s.deployments[envName][dep.DeploymentName] != nil:
+    writeError(w, http.StatusConflict, fmt.Sprintf("deployment %s already exists in %s", dep.DeploymentName, envName))
+    return
+  }
+
+  if dep.EnvVars == nil {
+    dep.EnvVars = []EnvVar{}
+  }
+
+  s.deployments[envName][dep.DeploymentName] = &dep
+  writeJSON(w, http.StatusCreated, &dep)
+}
+
+// patchDeployment changes the fields given. Environment variables, when given,
+// replace all of those of the deployment, as Enrober does.
+func (s *Server) patchDeployment(w http.ResponseWriter, r *http.Request, dep *Deployment) {
+  patch := deploymentPatch{}
+  if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid deployment patch: " + err.Error())
+    return
+  }
+
+  if patch.Replicas != nil && *patch.Replicas < 0 {
+    writeError(w, http.StatusBadRequest, "replicas must not be negative")
+    return
+  }
+
+  if patch.PublicHosts != nil {
+    dep.PublicHosts = *patch.PublicHosts
+  }
+  if patch.PrivateHosts != nil {
+    dep.PrivateHosts = *patch.PrivateHosts
+  }
+  if patch.Replicas != nil {
+    dep.Replicas = *patch.Replicas
+  }
+  if patch.PtsURL != nil {
+    dep.PtsURL = *patch.PtsURL
+  }
+  if patch.EnvVars != nil {
+    dep.EnvVars = patch.EnvVars
+  }
+
+  writeJSON(w, http.StatusOK, dep)
+}
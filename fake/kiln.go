@@ -0,0 +1,203 @@
+package fake
+
+import (
+  "fmt"
+  "net/http"
+  "sort"
+  "time"
+)
+
+// largest app zip accepted by the build API
+const maxImageSize = 100 << 20
+
+// Image an image built by Kiln from a zipped Node.js app
+type Image struct {
+  Name string `json:"name"`
+  Revision string `json:"revision"`
+  PublicPath string `json:"publicPath"`
+  NodeVersion string `json:"nodeVersion"`
+  EnvVars []string `json:"envVars"`
+  Size int64 `json:"size"`
+  Created time.Time `json:"created"`
+}
+
+// application an app of an imagespace, listed by the imagespace
+type application struct {
+  Name string `json:"name"`
+  Revisions []string `json:"revisions"`
+}
+
+// AddImage adds an image to the imagespace of the org, e.g. to set up a test. It reports
+// whether there was no image of the app and revision yet.
+func (s *Server) AddImage(org string, image Image) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  return s.addImage(org, image)
+}
+
+// GetImage the image of the given app and revision in the imagespace of the org, if any
+func (s *Server) GetImage(org string, appName string, revision string) (Image, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  image, ok := s.images[org][appName][revision]
+  if !ok {
+    return Image{}, false
+  }
+
+  return *image, true
+}
+
+func (s *Server) addImage(org string, image Image) bool {
+  if s.images[org] == nil {
+    s.images[org] = map[string]map[string]*Image{}
+  }
+
+  if s.images[org][image.Name] == nil {
+    s.images[org][image.Name] = map[string]*Image{}
+  }
+
+  if s.images[org][image.Name][image.Revision] != nil {
+    return false
+  }
+
+  if image.Created.IsZero() {
+    image.Created = time.Now().UTC()
+  }
+
+  if image.EnvVars == nil {
+    image.EnvVars = []string{}
+  }
+
+  s.images[org][image.Name][image.Revision] = &image
+  return true
+}
+
+// serveKiln the imagespaces API of an org, below /imagespaces/<org>/images
+func (s *Server) serveKiln(w http.ResponseWriter, r *http.Request, org string, parts []string) {
+  switch {
+  case len(parts) == 0 && r.Method == "GET":
+    s.listApplications(w, org)
+  case len(parts) == 0 && r.Method == "POST":
+    s.buildImage(w, r, org)
+  case len(parts) == 1 && r.Method == "GET":
+    revisions := s.images[org][parts[0]]
+    if len(revisions) == 0 {
+      writeError(w, http.StatusNotFound, fmt.Sprintf("application %s not found", parts[0]))
+      return
+    }
+
+    list := []*Image{}
+    for _, revision := range sortedRevisions(revisions) {
+      list = append(list, revisions[revision])
+    }
+
+    writeJSON(w, http.StatusOK, list)
+  case len(parts) >= 3 && parts[1] == "version":
+    s.serveImage(w, r, org, parts[0], parts[2], parts[3:])
+  case len(parts) <= 1:
+    methodNotAllowed(w, r)
+  default:
+    writeError(w, http.StatusNotFound, "no such API")
+  }
+}
+
+func (s *Server) listApplications(w http.ResponseWriter, org string) {
+  names := []string{}
+  for name, revisions := range s.images[org] {
+    if len(revisions) > 0 {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+
+  apps := []application{}
+  for _, name := range names {
+    apps = append(apps, application{name, sortedRevisions(s.images[org][name])})
+  }
+
+  writeJSON(w, http.StatusOK, apps)
+}
+
+// buildImage "builds" the image from a multipart form as sent by create image,
+// requiring a zip file, a name, a revision and a public path
+func (s *Server) buildImage(w http.ResponseWriter, r *http.Request, org string) {
+  if err := r.ParseMultipartForm(maxImageSize); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid build request: " + err.Error())
+    return
+  }
+
+  file, header, err := r.FormFile("file")
+  if err != nil {
+    writeError(w, http.StatusBadRequest, "an app zip is required as file")
+    return
+  }
+  file.Close()
+
+  image := Image{
+    Name: r.FormValue("name"),
+    Revision: r.FormValue("revision"),
+    PublicPath: r.FormValue("publicPath"),
+    NodeVersion: r.FormValue("nodeVersion"),
+    EnvVars: r.MultipartForm.Value["envVar"],
+    Size: header.Size,
+  }
+
+  switch {
+  case image.Name == "" || image.Revision == "" || image.PublicPath == "":
+    writeError(w, http.StatusBadRequest, "name, revision and publicPath are required")
+  case !s.addImage(org, image):
+    writeError(w, http.StatusConflict, fmt.Sprintf("image %s revision %s already exists", image.Name, image.Revision))
+  default:
+    writeJSON(w, http.StatusCreated, s.images[org][image.Name][image.Revision])
+  }
+}
+
+func (s *Server) serveImage(w http.ResponseWriter, r *http.Request, org string, appName string, revision string, parts []string) {
+  image := s.images[org][appName][revision]
+  if image == nil {
+    writeError(w, http.StatusNotFound, fmt.Sprintf("image %s revision %s not found", appName, revision))
+    return
+  }
+
+  switch {
+  case len(parts) == 1 && parts[0] == "podtemplatespec" && r.Method == "GET":
+    writeJSON(w, http.StatusOK, podTemplateSpec(org, image))
+  case len(parts) != 0:
+    writeError(w, http.StatusNotFound, "no such API")
+  case r.Method == "GET":
+    writeJSON(w, http.StatusOK, image)
+  case r.Method == "DELETE":
+    delete(s.images[org][appName], revision)
+    writeJSON(w, http.StatusOK, image)
+  default:
+    methodNotAllowed(w, r)
+  }
+}
+
+// podTemplateSpec a Kubernetes pod template running the image, as Enrober reads it
+func podTemplateSpec(org string, image *Image) map[string]interface{} {
+  return map[string]interface{}{
+    "metadata": map[string]interface{}{
+      "annotations": map[string]string{"publicPaths": image.PublicPath},
+      "labels": map[string]string{"app": image.Name, "revision": image.Revision},
+    },
+    "spec": map[string]interface{}{
+      "containers": []map[string]interface{}{{
+        "name": image.Name,
+        "image": fmt.Sprintf("fake-registry/%s/%s:%s", org, image.Name, image.Revision),
+      }},
+    },
+  }
+}
+
+func sortedRevisions(revisions map[string]*Image) []string {
+  list := []string{}
+  for revision := range revisions {
+    list = append(list, revision)
+  }
+  sort.Strings(list)
+
+  return list
+}
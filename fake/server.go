@@ -0,0 +1,92 @@
+// Package fake is an in-memory Shipyard: the Kiln imagespaces API, the Enrober
+// environments API and the SSO token endpoint, enough to run every shipyardctl
+// command against without a cluster. For end-to-end tests, serve it with httptest:
+//
+//   server := httptest.NewServer(fake.NewServer())
+//   defer server.Close()
+//   // point --cluster-target and --sso-target at server.URL
+package fake
+
+import (
+  "encoding/json"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Server the fake Shipyard, an http.Handler. Its state lives in memory and is
+// safe to use from several goroutines.
+type Server struct {
+  // Users the username and password pairs accepted by the password grant.
+  // Any username and password are accepted when there are none.
+  Users map[string]string
+
+  // Clients the client id and secret pairs of service accounts accepted
+  // by the client credentials grant. Any are accepted when there are none.
+  Clients map[string]string
+
+  // TokenLifetime how long issued access tokens are valid, an hour if zero
+  TokenLifetime time.Duration
+
+  mu sync.Mutex
+  environments map[string]*Environment
+  deployments map[string]map[string]*Deployment // by environment, then name
+  images map[string]map[string]map[string]*Image // by org, app name, then revision
+  refreshTokens map[string]string // username by refresh token
+  revoked map[string]bool // jti of revoked tokens
+  issued int
+}
+
+// NewServer a fake Shipyard without any environments, deployments or images
+func NewServer() *Server {
+  return &Server{
+    Users: map[string]string{},
+    Clients: map[string]string{},
+    environments: map[string]*Environment{},
+    deployments: map[string]map[string]*Deployment{},
+    images: map[string]map[string]map[string]*Image{},
+    refreshTokens: map[string]string{},
+    revoked: map[string]bool{},
+  }
+}
+
+// ServeHTTP routes the request to the SSO, Enrober or Kiln API by its path
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+  switch {
+  case len(parts) >= 2 && parts[0] == "oauth" && parts[1] == "token":
+    s.serveSSO(w, r, parts[2:])
+  case len(parts) == 2 && parts[1] == "status" && (parts[0] == "environments" || parts[0] == "imagespaces"):
+    w.Header().Set("Content-Type", "text/plain")
+    w.Write([]byte("OK\n"))
+  case !s.authorized(r):
+    writeError(w, http.StatusUnauthorized, "missing, expired or revoked token")
+  case parts[0] == "environments":
+    s.serveEnrober(w, r, parts[1:])
+  case parts[0] == "imagespaces" && len(parts) >= 3 && parts[2] == "images":
+    s.serveKiln(w, r, parts[1], parts[3:])
+  default:
+    writeError(w, http.StatusNotFound, "no such API")
+  }
+}
+
+// writeJSON writes the value as the JSON body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error response in the shape of the Shipyard APIs
+func writeError(w http.ResponseWriter, status int, message string) {
+  writeJSON(w, status, map[string]string{"message": message})
+}
+
+// methodNotAllowed responds to a method the resource does not support
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+  writeError(w, http.StatusMethodNotAllowed, r.Method + " is not supported on " + r.URL.Path)
+}
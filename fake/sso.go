@@ -0,0 +1,154 @@
+package fake
+
+import (
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/30x/shipyardctl/utils"
+)
+
+// tokenResponse the response of the token endpoint
+type tokenResponse struct {
+  AccessToken string `json:"access_token"`
+  RefreshToken string `json:"refresh_token"`
+  ExpiresIn int64 `json:"expires_in"`
+  TokenType string `json:"token_type"`
+}
+
+// serveSSO the token endpoint, for the password, passcode, client credentials and
+// refresh token grants, and the revocation of tokens
+func (s *Server) serveSSO(w http.ResponseWriter, r *http.Request, parts []string) {
+  if len(parts) == 2 && parts[0] == "revoke" {
+    if r.Method != "DELETE" {
+      methodNotAllowed(w, r)
+      return
+    }
+
+    s.revoked[parts[1]] = true
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+    return
+  }
+
+  if len(parts) != 0 || r.Method != "POST" {
+    writeError(w, http.StatusNotFound, "no such API")
+    return
+  }
+
+  if err := r.ParseForm(); err != nil {
+    writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+    return
+  }
+
+  clientID, _, ok := r.BasicAuth()
+  if !ok {
+    writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized", "error_description": "Missing client credentials"})
+    return
+  }
+
+  username := ""
+  switch r.PostForm.Get("grant_type") {
+  case "password":
+    username = r.PostForm.Get("username")
+    if passcode := r.PostForm.Get("passcode"); passcode != "" {
+      username = "passcode-user@example.com"
+    } else if !accepts(s.Users, username, r.PostForm.Get("password")) {
+      writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized", "error_description": "Bad credentials"})
+      return
+    }
+  case "client_credentials":
+    _, secret, _ := r.BasicAuth()
+    if !accepts(s.Clients, clientID, secret) {
+      writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": "Bad client credentials"})
+      return
+    }
+  case "refresh_token":
+    var known bool
+    if username, known = s.refreshTokens[r.PostForm.Get("refresh_token")]; !known {
+      writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token", "error_description": "Invalid refresh token"})
+      return
+    }
+  default:
+    writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type", "error_description": "Unsupported grant type"})
+    return
+  }
+
+  writeJSON(w, http.StatusOK, s.issueToken(username, clientID))
+}
+
+// accepts reports whether the name and secret are among the pairs, any are when there are no pairs
+func accepts(pairs map[string]string, name string, secret string) bool {
+  if len(pairs) == 0 {
+    return name != ""
+  }
+
+  expected, ok := pairs[name]
+  return ok && expected == secret
+}
+
+// issueToken an unsigned JWT for the user, or the client when there is no user,
+// along with a refresh token unless issued to a client
+func (s *Server) issueToken(username string, clientID string) tokenResponse {
+  lifetime := s.TokenLifetime
+  if lifetime == 0 {
+    lifetime = time.Hour
+  }
+
+  s.issued++
+  now := time.Now()
+  claims := utils.TokenClaims{
+    ID: fmt.Sprintf("fake-%d", s.issued),
+    Subject: firstOf(username, clientID),
+    UserName: username,
+    Email: username,
+    ClientID: clientID,
+    Issuer: "fake-sso",
+    Scope: []string{"openid", "scim.me"},
+    IssuedAt: now.Unix(),
+    ExpiresAt: now.Add(lifetime).Unix(),
+  }
+
+  response := tokenResponse{AccessToken: encodeToken(claims), ExpiresIn: int64(lifetime / time.Second), TokenType: "bearer"}
+  if username != "" {
+    response.RefreshToken = fmt.Sprintf("fake-refresh-%d", s.issued)
+    s.refreshTokens[response.RefreshToken] = username
+  }
+
+  return response
+}
+
+// encodeToken an unsigned JWT carrying the claims
+func encodeToken(claims utils.TokenClaims) string {
+  header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+  payload, _ := json.Marshal(claims)
+  return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".fake"
+}
+
+// authorized reports whether the request carries a bearer token that is neither
+// revoked nor expired. Tokens that are not JWTs, e.g. given with --token in tests, are accepted.
+func (s *Server) authorized(r *http.Request) bool {
+  auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+  if len(auth) != 2 || !strings.EqualFold(auth[0], "Bearer") || auth[1] == "" {
+    return false
+  }
+
+  claims, err := utils.DecodeToken(auth[1])
+  if err != nil {
+    return true
+  }
+
+  return !s.revoked[claims.ID] && (claims.ExpiresAt == 0 || time.Now().Unix() < claims.ExpiresAt)
+}
+
+func firstOf(values ...string) string {
+  for _, value := range values {
+    if value != "" {
+      return value
+    }
+  }
+
+  return ""
+}
@@ -18,7 +18,6 @@ import (
   "fmt"
   "os"
   "path/filepath"
-  "io/ioutil"
 
   yaml "gopkg.in/yaml.v2"
 )
@@ -28,27 +27,30 @@ const (
   ShipyardctlConfigDir = ".shipyardctl"
   // ShipyardctlConfigFileName name of the config file for shipyardctl
   ShipyardctlConfigFileName = "config"
+  // DefaultClientID OAuth client used to login when a context does not configure one
+  DefaultClientID = "edgecli"
+  // DefaultClientSecret secret of the default OAuth client
+  DefaultClientSecret = "edgeclisecret"
 )
 
 // InitNewConfigFile creates a new config file
 func InitNewConfigFile(name string, sso string, clusterTarget string) error {
 
-  home, err := homedir()
+  configFilePath, err := getConfigPath()
   if err != nil {
     return err
   }
 
-  configDirPath := filepath.Join(home, ShipyardctlConfigDir)
-  configFilePath := filepath.Join(configDirPath, ShipyardctlConfigFileName)
+  configDirPath := filepath.Dir(configFilePath)
 
   // make sure the directory is there
-  fmt.Println("Creating configuration directory at:", configDirPath)
-  err = os.MkdirAll(configDirPath, 0755)
+  fmt.Fprintln(os.Stderr, "Creating configuration directory at:", configDirPath)
+  err = os.MkdirAll(configDirPath, 0700)
   if err != nil {
     return err
   }
 
-  fmt.Println("Creating configuration file at:", configFilePath)
+  fmt.Fprintln(os.Stderr, "Creating configuration file at:", configFilePath)
 
   // default config
   defaultConfig := MakeConfig(name, sso, clusterTarget)
@@ -57,26 +59,216 @@ func InitNewConfigFile(name string, sso string, clusterTarget string) error {
     return err
   }
 
-  return ioutil.WriteFile(configFilePath, data, 0755)
+  return writeFilePrivate(configFilePath, data)
 }
 
 // MakeConfig creates a context named default based on the given environment
 func MakeConfig(name string, sso string, clusterTarget string) *Config {
-  cluster := Cluster{name, clusterTarget, sso}
-  context := Context{name, cluster, User{}}
+  cluster := Cluster{Name: name, Cluster: clusterTarget, SSO: sso}
+  context := Context{Name: name, ClusterInfo: cluster}
 
-  return &Config{name, []Context{context}}
+  return &Config{APIVersion: ConfigAPIVersion, CurrentContext: name, Contexts: []Context{context}}
+}
+
+// NewInMemoryConfig creates a config that is never written to file, for when
+// there is no place to keep one (e.g. no home directory or a read-only one)
+func NewInMemoryConfig(name string, sso string, clusterTarget string) *Config {
+  config := MakeConfig(name, sso, clusterTarget)
+  config.inMemory = true
+
+  return config
+}
+
+// KeepInMemory stops the changes to the config, credentials included, from being
+// written for the rest of the run, e.g. while replaying recorded API calls
+func (c *Config) KeepInMemory() error {
+  store, err := c.credentialStore()
+  if err != nil {
+    return err
+  }
+
+  if store.Name() != PlainCredentialStore { // the tokens of a plain store are in c already
+    c.store = &memoryStore{saved: store, changed: map[string]Credentials{}}
+  }
+  c.inMemory = true
+
+  return nil
+}
+
+// Path the config file changes are written to, empty for an in-memory config
+func (c *Config) Path() string {
+  if c.inMemory {
+    return ""
+  }
+
+  if len(c.sources) > 0 {
+    return c.sources[0].path
+  }
+
+  path, err := getConfigPath()
+  if err != nil {
+    return ""
+  }
+
+  return path
 }
 
 // GetCurrentToken retrieves the user token from the current active context
 func (c *Config) GetCurrentToken() string {
-  for _, con := range c.Contexts {
-    if con.Name == c.CurrentContext {
-      return con.UserInfo.Token
+  return c.currentCredentials().Token
+}
+
+// GetCurrentRefreshToken retrieves the refresh token from the current active context
+func (c *Config) GetCurrentRefreshToken() string {
+  return c.currentCredentials().RefreshToken
+}
+
+// GetCredentials retrieves the tokens of the named context from the credential store
+func (c *Config) GetCredentials(name string) (Credentials, error) {
+  store, err := c.credentialStore()
+  if err != nil {
+    return Credentials{}, err
+  }
+
+  return store.Get(name)
+}
+
+func (c *Config) currentCredentials() Credentials {
+  store, err := c.credentialStore()
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    return Credentials{}
+  }
+
+  creds, err := store.Get(c.CurrentContext)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, "Unable to read credentials:", err)
+    return Credentials{}
+  }
+
+  return creds
+}
+
+// credentialStore opens the configured credential store once
+func (c *Config) credentialStore() (CredentialStore, error) {
+  if c.store == nil {
+    store, err := NewCredentialStore(c.CredentialStore, c)
+    if err != nil {
+      return nil, err
+    }
+
+    c.store = store
+  }
+
+  return c.store, nil
+}
+
+// MigrateCredentials moves the credentials of every context to the named store
+func (c *Config) MigrateCredentials(kind string, keyFile string) error {
+  return c.update(func(c *Config) error {
+    from, err := c.credentialStore()
+    if err != nil {
+      return err
+    }
+
+    next := *c
+    next.CredentialKeyFile = keyFile
+    to, err := NewCredentialStore(kind, &next)
+    if err != nil {
+      return err
+    }
+
+    if kind == PlainCredentialStore {
+      to = &plainStore{c}
     }
+
+    if from.Name() == to.Name() && c.CredentialKeyFile == keyFile {
+      return fmt.Errorf("Credentials are already kept in the %s store", kind)
+    }
+
+    // read everything first, so a bad passphrase or keyring fails before anything moves
+    all := map[string]Credentials{}
+    for ndx, con := range c.Contexts {
+      creds, err := from.Get(con.Name)
+      if err != nil {
+        return err
+      }
+
+      // client secrets were kept in the config file whatever the store, move those along
+      if creds.ClientSecret == "" {
+        creds.ClientSecret = con.ClusterInfo.ClientSecret
+      }
+      c.Contexts[ndx].ClusterInfo.ClientSecret = ""
+
+      all[con.Name] = creds
+    }
+
+    for name, creds := range all {
+      if from.Name() != PlainCredentialStore {
+        if err = from.Delete(name); err != nil {
+          return err
+        }
+      } else {
+        from.Set(name, Credentials{}) // clear the tokens and client secret from the config file
+      }
+
+      if err = to.Set(name, creds); err != nil {
+        return err
+      }
+    }
+
+    c.CredentialStore = kind
+    c.CredentialKeyFile = keyFile
+    c.store = to
+    return nil
+  })
+}
+
+// update applies the change to the config as it is on disk and saves it, holding
+// the lock of the config files throughout. Re-reading the files first merges in
+// what other shipyardctl processes changed since this one loaded them (e.g. tokens
+// saved by a parallel login) instead of overwriting it.
+func (c *Config) update(change func(c *Config) error) error {
+  if c.inMemory {
+    return change(c)
+  }
+
+  unlock, err := lockConfig()
+  if err != nil {
+    return err
   }
+  defer unlock()
 
-  return "" // couldn't find the current Context
+  fresh, err := LoadConfig()
+  if err != nil {
+    return err
+  }
+
+  // keep using the context of this process, without saving it as the current one
+  if fresh.CurrentContext != c.CurrentContext && fresh.hasContext(c.CurrentContext) {
+    fresh.savedContext = fresh.CurrentContext
+    fresh.CurrentContext = c.CurrentContext
+  }
+
+  // reuse an opened store, which may hold the passphrase already
+  if c.store != nil && c.store.Name() != PlainCredentialStore && fresh.CredentialStore == c.CredentialStore {
+    fresh.store = c.store
+  }
+
+  if err = change(fresh); err != nil {
+    return err
+  }
+
+  if err = fresh.Save(); err != nil {
+    return err
+  }
+
+  *c = *fresh
+  if _, plain := c.store.(*plainStore); plain {
+    c.store = &plainStore{c}
+  }
+
+  return nil
 }
 
 // GetCurrentContext retrieves the current context
@@ -92,55 +284,365 @@ func (c *Config) GetCurrentContext() *Context {
 
 // SetContext switch current context to given context name
 func (c *Config) SetContext(name string) error {
+  return c.update(func(c *Config) error {
+    for _, con := range c.Contexts {
+      if con.Name == name { // valid context name
+        c.CurrentContext = name // set current context
+        c.savedContext = ""
+        return nil
+      }
+    }
+
+    return fmt.Errorf("Invalid context name: %s", name)
+  })
+}
+
+// OverrideContext switches to the given context for this process only,
+// leaving the current context stored in the config file untouched
+func (c *Config) OverrideContext(name string) error {
   for _, con := range c.Contexts {
     if con.Name == name { // valid context name
-      c.CurrentContext = name // set current context
-      return c.Save() // save change
+      if c.savedContext == "" {
+        c.savedContext = c.CurrentContext
+      }
+
+      c.CurrentContext = name
+      return nil
     }
   }
 
   return fmt.Errorf("Invalid context name: %s", name)
 }
 
-// Save writes the config out to file
+// Save writes the config out to file. When several config files were merged,
+// each context goes back to the file it came from, while new contexts and the
+// settings go to the first one.
 func (c *Config) Save() error {
-  data, err := yaml.Marshal(c)
-  if err != nil {
-    return err
+  if c.inMemory {
+    return nil
   }
 
-  path, err := getConfigPath()
+  saved := *c
+  saved.APIVersion = ConfigAPIVersion
+  if c.savedContext != "" {
+    saved.CurrentContext = c.savedContext
+  }
+
+  if len(c.sources) == 1 {
+    return writeConfig(c.sources[0].path, &saved)
+  } else if len(c.sources) == 0 {
+    path, err := getConfigPath()
+    if err != nil {
+      return err
+    }
+
+    return writeConfig(path, &saved)
+  }
+
+  for ndx, src := range c.sources {
+    file := src.settings
+    file.APIVersion = ConfigAPIVersion
+    if ndx == 0 {
+      file.CurrentContext = saved.CurrentContext
+      file.CredentialStore = saved.CredentialStore
+      file.CredentialKeyFile = saved.CredentialKeyFile
+    }
+
+    file.Contexts = []Context{}
+    for _, con := range c.Contexts {
+      if con.source == src.path || (ndx == 0 && !c.isSource(con.source)) {
+        file.Contexts = append(file.Contexts, con)
+      }
+    }
+
+    if err := writeConfig(src.path, &file); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func (c *Config) isSource(path string) bool {
+  for _, src := range c.sources {
+    if src.path == path {
+      return true
+    }
+  }
+
+  return false
+}
+
+func writeConfig(path string, c *Config) error {
+  data, err := yaml.Marshal(c)
   if err != nil {
     return err
   }
 
-  return ioutil.WriteFile(path, data, 0755)
+  return writeFilePrivate(path, data)
 }
 
 // GetCurrentClusterTarget retrieves current context cluster target
 func (c *Config) GetCurrentClusterTarget() string {
   context := c.GetCurrentContext()
+  if context == nil {
+    return ""
+  }
+
   return context.ClusterInfo.Cluster
 }
 
 // GetCurrentSSOTarget retrieves current context sso target
 func (c *Config) GetCurrentSSOTarget() string {
   context := c.GetCurrentContext()
+  if context == nil {
+    return ""
+  }
+
   return context.ClusterInfo.SSO
 }
 
+// GetCurrentOAuthClient retrieves the OAuth client id and secret used to login
+// in the current context, defaulting to edgecli. The secret is read from the
+// credential store, or from the config file where older versions kept it.
+func (c *Config) GetCurrentOAuthClient() (string, string) {
+  context := c.GetCurrentContext()
+  if context == nil || context.ClusterInfo.ClientID == "" {
+    return DefaultClientID, DefaultClientSecret
+  }
+
+  secret := c.currentCredentials().ClientSecret
+  if secret == "" {
+    secret = context.ClusterInfo.ClientSecret
+  }
+
+  return context.ClusterInfo.ClientID, secret
+}
+
 // GetCurrentUsername retrieves the username of the current context
 func (c *Config) GetCurrentUsername() string {
   context := c.GetCurrentContext()
+  if context == nil {
+    return ""
+  }
+
   return context.UserInfo.Username
 }
 
-// NewContext used to create a new context
-func (c *Config) NewContext(name string, sso string, clusterTarget string) error {
-  c.Contexts = append(c.Contexts, Context{name, Cluster{name, clusterTarget, sso}, User{}})
-  c.Save()
+// GetCurrentOrg retrieves the default org of the current context
+func (c *Config) GetCurrentOrg() string {
+  context := c.GetCurrentContext()
+  if context == nil {
+    return ""
+  }
 
-  return nil
+  return context.Org
+}
+
+// GetCurrentEnvironment retrieves the default environment name of the current context
+func (c *Config) GetCurrentEnvironment() string {
+  context := c.GetCurrentContext()
+  if context == nil {
+    return ""
+  }
+
+  return context.Environment
+}
+
+// NewContext used to create a new context for the given cluster
+func (c *Config) NewContext(name string, cluster Cluster) error {
+  return c.update(func(c *Config) error {
+    if c.hasContext(name) {
+      return fmt.Errorf("Context %s already exists", name)
+    }
+
+    secret := cluster.ClientSecret
+    cluster.Name = name
+    cluster.ClientSecret = ""
+    c.Contexts = append(c.Contexts, Context{Name: name, ClusterInfo: cluster})
+
+    if secret == "" {
+      return nil
+    }
+
+    return c.setClientSecret(name, secret)
+  })
+}
+
+// SetClientSecret keeps the secret of the OAuth client of the named context
+// in the credential store, along with its tokens
+func (c *Config) SetClientSecret(name string, secret string) error {
+  return c.update(func(c *Config) error {
+    if !c.hasContext(name) {
+      return fmt.Errorf("Invalid context name: %s", name)
+    }
+
+    return c.setClientSecret(name, secret)
+  })
+}
+
+func (c *Config) setClientSecret(name string, secret string) error {
+  store, err := c.credentialStore()
+  if err != nil {
+    return err
+  }
+
+  creds, err := store.Get(name)
+  if err != nil {
+    return err
+  }
+
+  // older versions kept it in the config file whatever the store
+  if store.Name() != PlainCredentialStore {
+    for ndx := range c.Contexts {
+      if c.Contexts[ndx].Name == name {
+        c.Contexts[ndx].ClusterInfo.ClientSecret = ""
+      }
+    }
+  }
+
+  creds.ClientSecret = secret
+  return store.Set(name, creds)
+}
+
+// ModifyContext applies the given changes to the named context and saves them
+func (c *Config) ModifyContext(name string, modify func(*Context)) error {
+  return c.update(func(c *Config) error {
+    for ndx, con := range c.Contexts {
+      if con.Name == name {
+        modify(&c.Contexts[ndx])
+        c.Contexts[ndx].Name = name // the name is changed with RenameContext only
+        return nil
+      }
+    }
+
+    return fmt.Errorf("Invalid context name: %s", name)
+  })
+}
+
+// DeleteContext removes the named context along with its credentials
+func (c *Config) DeleteContext(name string) error {
+  return c.update(func(c *Config) error {
+    if name == c.CurrentContext {
+      return fmt.Errorf("Cannot delete the current context %s, switch to another one first", name)
+    }
+
+    store, err := c.credentialStore()
+    if err != nil {
+      return err
+    }
+
+    for ndx, con := range c.Contexts {
+      if con.Name == name {
+        if err = store.Delete(name); err != nil {
+          return err
+        }
+
+        c.Contexts = append(c.Contexts[:ndx], c.Contexts[ndx+1:]...)
+        return nil
+      }
+    }
+
+    return fmt.Errorf("Invalid context name: %s", name)
+  })
+}
+
+// RenameContext renames a context, moving its credentials along
+func (c *Config) RenameContext(name string, newName string) error {
+  return c.update(func(c *Config) error {
+    if c.hasContext(newName) {
+      return fmt.Errorf("Context %s already exists", newName)
+    }
+
+    store, err := c.credentialStore()
+    if err != nil {
+      return err
+    }
+
+    for ndx, con := range c.Contexts {
+      if con.Name == name {
+        creds, err := store.Get(name)
+        if err != nil {
+          return err
+        }
+
+        c.Contexts[ndx].Name = newName
+        c.Contexts[ndx].ClusterInfo.Name = newName
+        if c.CurrentContext == name {
+          c.CurrentContext = newName
+        }
+        if c.savedContext == name {
+          c.savedContext = newName
+        }
+
+        if store.Name() != PlainCredentialStore {
+          if err = store.Set(newName, creds); err != nil {
+            return err
+          }
+
+          if err = store.Delete(name); err != nil {
+            return err
+          }
+        }
+
+        return nil
+      }
+    }
+
+    return fmt.Errorf("Invalid context name: %s", name)
+  })
+}
+
+func (c *Config) hasContext(name string) bool {
+  for _, con := range c.Contexts {
+    if con.Name == name {
+      return true
+    }
+  }
+
+  return false
+}
+
+// ClearUserInfo wipes the credentials of the named context
+func (c *Config) ClearUserInfo(name string) error {
+  return c.update(func(c *Config) error {
+    store, err := c.credentialStore()
+    if err != nil {
+      return err
+    }
+
+    for ndx, con := range c.Contexts {
+      if con.Name == name {
+        c.Contexts[ndx].UserInfo = User{}
+        if err = clearTokens(store, name); err != nil {
+          return err
+        }
+
+        return nil
+      }
+    }
+
+    return fmt.Errorf("Invalid context name: %s", name)
+  })
+}
+
+// ClearAllUserInfo wipes the credentials of every context
+func (c *Config) ClearAllUserInfo() error {
+  return c.update(func(c *Config) error {
+    store, err := c.credentialStore()
+    if err != nil {
+      return err
+    }
+
+    for ndx, con := range c.Contexts {
+      c.Contexts[ndx].UserInfo = User{}
+      if err = clearTokens(store, con.Name); err != nil {
+        return err
+      }
+    }
+
+    return nil
+  })
 }
 
 // DumpConfig dumps the config to stdout
@@ -155,15 +657,28 @@ func (c *Config) DumpConfig() error {
   return nil
 }
 
-// SaveToken writes the given username and token to the current context
-func (c *Config) SaveToken(username string, token string) error {
-  user := User{username, token}
-  for ndx, con := range c.Contexts {
-    if con.Name == c.CurrentContext {
-      c.Contexts[ndx].UserInfo = user
-      return c.Save()
+// SaveToken writes the given username, tokens and expiry to the current context
+func (c *Config) SaveToken(username string, token string, refreshToken string, expiresAt int64) error {
+  return c.update(func(c *Config) error {
+    store, err := c.credentialStore()
+    if err != nil {
+      return err
+    }
+
+    for ndx, con := range c.Contexts {
+      if con.Name == c.CurrentContext {
+        c.Contexts[ndx].UserInfo = User{Username: username, ExpiresAt: expiresAt}
+
+        creds, err := store.Get(con.Name)
+        if err != nil {
+          return err
+        }
+
+        creds.Token, creds.RefreshToken = token, refreshToken
+        return store.Set(con.Name, creds)
+      }
     }
-  }
 
-  return fmt.Errorf("Could not find current context: %s", c.CurrentContext)
+    return fmt.Errorf("Could not find current context: %s", c.CurrentContext)
+  })
 }
@@ -0,0 +1,396 @@
+package utils
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+
+  "golang.org/x/crypto/scrypt"
+)
+
+const (
+  // PlainCredentialStore keeps tokens in the config file itself
+  PlainCredentialStore = "plain"
+  // KeyringCredentialStore keeps tokens in the OS keyring
+  KeyringCredentialStore = "keyring"
+  // EncryptedFileCredentialStore keeps tokens in a file encrypted with a passphrase or key file
+  EncryptedFileCredentialStore = "encrypted-file"
+
+  // ShipyardctlCredentialsFileName name of the encrypted credentials file, next to the config file
+  ShipyardctlCredentialsFileName = "credentials.enc"
+
+  // service name the keyring entries are filed under
+  keyringService = "shipyardctl"
+)
+
+// PassphrasePrompt asks for the passphrase of the encrypted credentials file when
+// neither SHIPYARDCTL_PASSPHRASE nor a key file is available. Set by the CLI.
+var PassphrasePrompt func() ([]byte, error)
+
+// Credentials the secret part of a context's user info and cluster info
+type Credentials struct {
+  Token string
+  RefreshToken string
+  ClientSecret string `json:",omitempty"` // of the context's OAuth client, kept across logins
+}
+
+// CredentialStore keeps the credentials of each context, keyed by context name
+type CredentialStore interface {
+  Name() string
+  Get(context string) (Credentials, error)
+  Set(context string, creds Credentials) error
+  Delete(context string) error
+}
+
+// NewCredentialStore opens the named credential store for the given config
+func NewCredentialStore(kind string, c *Config) (CredentialStore, error) {
+  switch kind {
+  case "", PlainCredentialStore:
+    return &plainStore{c}, nil
+  case KeyringCredentialStore:
+    return &keyringStore{}, nil
+  case EncryptedFileCredentialStore:
+    path, err := getCredentialsPath()
+    if err != nil {
+      return nil, err
+    }
+
+    return &encryptedFileStore{path: path, keyFile: c.CredentialKeyFile}, nil
+  }
+
+  return nil, fmt.Errorf("Unknown credential store: %s. Use one of %s, %s or %s",
+    kind, PlainCredentialStore, KeyringCredentialStore, EncryptedFileCredentialStore)
+}
+
+// plainStore the legacy store, keeping tokens in the user info of the config
+// file and the client secret in its cluster info
+type plainStore struct {
+  config *Config
+}
+
+func (s *plainStore) Name() string {
+  return PlainCredentialStore
+}
+
+func (s *plainStore) Get(context string) (Credentials, error) {
+  for _, con := range s.config.Contexts {
+    if con.Name == context {
+      return Credentials{con.UserInfo.Token, con.UserInfo.RefreshToken, con.ClusterInfo.ClientSecret}, nil
+    }
+  }
+
+  return Credentials{}, nil
+}
+
+func (s *plainStore) Set(context string, creds Credentials) error {
+  for ndx, con := range s.config.Contexts {
+    if con.Name == context {
+      s.config.Contexts[ndx].UserInfo.Token = creds.Token
+      s.config.Contexts[ndx].UserInfo.RefreshToken = creds.RefreshToken
+      s.config.Contexts[ndx].ClusterInfo.ClientSecret = creds.ClientSecret
+      return nil
+    }
+  }
+
+  return fmt.Errorf("Invalid context name: %s", context)
+}
+
+func (s *plainStore) Delete(context string) error {
+  return s.Set(context, Credentials{})
+}
+
+// clearTokens removes the tokens of the context from the store, keeping its client secret
+func clearTokens(store CredentialStore, context string) error {
+  creds, err := store.Get(context)
+  if err != nil {
+    return err
+  }
+
+  if creds.ClientSecret == "" {
+    return store.Delete(context)
+  }
+
+  return store.Set(context, Credentials{ClientSecret: creds.ClientSecret})
+}
+
+// memoryStore keeps the changes to the credentials of another store in memory,
+// reading the credentials not changed yet from that store
+type memoryStore struct {
+  saved CredentialStore
+  changed map[string]Credentials
+}
+
+func (s *memoryStore) Name() string {
+  return s.saved.Name()
+}
+
+func (s *memoryStore) Get(context string) (Credentials, error) {
+  if creds, ok := s.changed[context]; ok {
+    return creds, nil
+  }
+
+  return s.saved.Get(context)
+}
+
+func (s *memoryStore) Set(context string, creds Credentials) error {
+  s.changed[context] = creds
+  return nil
+}
+
+func (s *memoryStore) Delete(context string) error {
+  return s.Set(context, Credentials{})
+}
+
+// keyringStore keeps each context's credentials as a secret in the OS keyring
+type keyringStore struct{}
+
+func (s *keyringStore) Name() string {
+  return KeyringCredentialStore
+}
+
+func (s *keyringStore) Get(context string) (Credentials, error) {
+  creds := Credentials{}
+  secret, err := keyringGet(context)
+  if err != nil || secret == "" {
+    return creds, err
+  }
+
+  err = json.Unmarshal([]byte(secret), &creds)
+  return creds, err
+}
+
+func (s *keyringStore) Set(context string, creds Credentials) error {
+  if creds == (Credentials{}) {
+    return s.Delete(context)
+  }
+
+  secret, err := json.Marshal(creds)
+  if err != nil {
+    return err
+  }
+
+  return keyringSet(context, string(secret))
+}
+
+func (s *keyringStore) Delete(context string) error {
+  return keyringDelete(context)
+}
+
+// encryptedFileStore keeps the credentials of all contexts in a single file,
+// sealed with AES-GCM under a key derived from a passphrase or read from a key file
+type encryptedFileStore struct {
+  path string
+  keyFile string
+  passphrase []byte
+}
+
+// encryptedFile the on-disk layout of the encrypted credentials file
+type encryptedFile struct {
+  Salt []byte
+  Nonce []byte
+  Data []byte
+}
+
+func (s *encryptedFileStore) Name() string {
+  return EncryptedFileCredentialStore
+}
+
+func (s *encryptedFileStore) Get(context string) (Credentials, error) {
+  all, err := s.load()
+  if err != nil {
+    return Credentials{}, err
+  }
+
+  return all[context], nil
+}
+
+func (s *encryptedFileStore) Set(context string, creds Credentials) error {
+  all, err := s.load()
+  if err != nil {
+    return err
+  }
+
+  all[context] = creds
+  return s.save(all)
+}
+
+func (s *encryptedFileStore) Delete(context string) error {
+  all, err := s.load()
+  if err != nil {
+    return err
+  }
+
+  delete(all, context)
+  return s.save(all)
+}
+
+func (s *encryptedFileStore) load() (map[string]Credentials, error) {
+  all := map[string]Credentials{}
+
+  data, err := ioutil.ReadFile(s.path)
+  if os.IsNotExist(err) {
+    return all, nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  file := encryptedFile{}
+  err = json.Unmarshal(data, &file)
+  if err != nil {
+    return nil, fmt.Errorf("%s is corrupt: %v", s.path, err)
+  }
+
+  aead, err := s.cipher(file.Salt)
+  if err != nil {
+    return nil, err
+  }
+
+  plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Unable to decrypt %s, wrong passphrase or key file?", s.path)
+  }
+
+  err = json.Unmarshal(plain, &all)
+  return all, err
+}
+
+func (s *encryptedFileStore) save(all map[string]Credentials) error {
+  if len(all) == 0 {
+    err := os.Remove(s.path)
+    if os.IsNotExist(err) {
+      return nil
+    }
+
+    return err
+  }
+
+  plain, err := json.Marshal(all)
+  if err != nil {
+    return err
+  }
+
+  file := encryptedFile{Salt: make([]byte, 16)}
+  if _, err = io.ReadFull(rand.Reader, file.Salt); err != nil {
+    return err
+  }
+
+  aead, err := s.cipher(file.Salt)
+  if err != nil {
+    return err
+  }
+
+  file.Nonce = make([]byte, aead.NonceSize())
+  if _, err = io.ReadFull(rand.Reader, file.Nonce); err != nil {
+    return err
+  }
+
+  file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+
+  data, err := json.Marshal(file)
+  if err != nil {
+    return err
+  }
+
+  return writeFilePrivate(s.path, data)
+}
+
+// cipher derives the AES-GCM cipher for the given salt from the key file, or else the passphrase
+func (s *encryptedFileStore) cipher(salt []byte) (cipher.AEAD, error) {
+  secret, err := s.secret()
+  if err != nil {
+    return nil, err
+  }
+
+  key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+  if err != nil {
+    return nil, err
+  }
+
+  block, err := aes.NewCipher(key)
+  if err != nil {
+    return nil, err
+  }
+
+  return cipher.NewGCM(block)
+}
+
+func (s *encryptedFileStore) secret() ([]byte, error) {
+  keyFile := s.keyFile
+  if env := os.Getenv("SHIPYARDCTL_KEY_FILE"); env != "" {
+    keyFile = env
+  }
+
+  if keyFile != "" {
+    key, err := ioutil.ReadFile(keyFile)
+    if err != nil {
+      return nil, err
+    }
+
+    return []byte(strings.TrimSpace(string(key))), nil
+  }
+
+  if s.passphrase == nil {
+    if env := os.Getenv("SHIPYARDCTL_PASSPHRASE"); env != "" {
+      s.passphrase = []byte(env)
+    } else if PassphrasePrompt != nil {
+      passphrase, err := PassphrasePrompt()
+      if err != nil {
+        return nil, err
+      }
+
+      s.passphrase = passphrase
+    }
+  }
+
+  if len(s.passphrase) == 0 {
+    return nil, fmt.Errorf("Missing passphrase for %s. Set SHIPYARDCTL_PASSPHRASE or a key file.", s.path)
+  }
+
+  return s.passphrase, nil
+}
+
+// writeFilePrivate writes the file readable by its owner only. The data is
+// written to a temporary file that then replaces the file, so that readers
+// never see it half written and a crash leaves the old file intact.
+func writeFilePrivate(path string, data []byte) error {
+  tmp, err := ioutil.TempFile(filepath.Dir(path), "." + filepath.Base(path) + ".tmp")
+  if err != nil {
+    return err
+  }
+
+  _, err = tmp.Write(data)
+  if err == nil {
+    err = tmp.Chmod(0600)
+  }
+  if err == nil {
+    err = tmp.Sync()
+  }
+  if closeErr := tmp.Close(); err == nil {
+    err = closeErr
+  }
+  if err == nil {
+    err = os.Rename(tmp.Name(), path)
+  }
+
+  if err != nil {
+    os.Remove(tmp.Name())
+  }
+
+  return err
+}
+
+func getCredentialsPath() (string, error) {
+  path, err := getConfigPath()
+  if err != nil {
+    return "", err
+  }
+
+  return filepath.Join(filepath.Dir(path), ShipyardctlCredentialsFileName), nil
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+const credentialsConfig = `apiVersion: v1
+currentcontext: dev
+contexts:
+- name: dev
+  clusterinfo:
+    cluster: https://dev.example.com
+    clientid: ci-bot
+    clientsecret: s3cret
+  userinfo:
+    username: me@example.com
+    token: the-token
+`
+
+func TestMigrateCredentialsMovesClientSecrets(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, credentialsConfig)
+  defer cleanup()
+
+  previous := os.Getenv("SHIPYARDCTL_PASSPHRASE")
+  os.Setenv("SHIPYARDCTL_PASSPHRASE", "passphrase")
+  defer os.Setenv("SHIPYARDCTL_PASSPHRASE", previous)
+
+  config, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  if err = config.MigrateCredentials(EncryptedFileCredentialStore, ""); err != nil {
+    t.Fatalf("MigrateCredentials: %v", err)
+  }
+
+  data, err := ioutil.ReadFile(paths[0])
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, secret := range []string{"s3cret", "the-token"} {
+    if strings.Contains(string(data), secret) {
+      t.Errorf("config file still holds %s:\n%s", secret, data)
+    }
+  }
+
+  config, err = LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  if id, secret := config.GetCurrentOAuthClient(); id != "ci-bot" || secret != "s3cret" {
+    t.Errorf("OAuth client is %s/%s, expected ci-bot/s3cret", id, secret)
+  }
+
+  // logging out drops the tokens but not the client secret
+  if err = config.ClearUserInfo("dev"); err != nil {
+    t.Fatalf("ClearUserInfo: %v", err)
+  }
+
+  if token := config.GetCurrentToken(); token != "" {
+    t.Errorf("token %q was kept after logout", token)
+  }
+
+  if _, secret := config.GetCurrentOAuthClient(); secret != "s3cret" {
+    t.Errorf("client secret is %q after logout, expected s3cret", secret)
+  }
+
+  if err = config.MigrateCredentials(PlainCredentialStore, ""); err != nil {
+    t.Fatalf("MigrateCredentials: %v", err)
+  }
+
+  if got := readConfigFile(t, paths[0]).Contexts[0].ClusterInfo.ClientSecret; got != "s3cret" {
+    t.Errorf("client secret in the config file is %q, expected s3cret", got)
+  }
+}
+
+func TestSetClientSecret(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, credentialsConfig)
+  defer cleanup()
+
+  config, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  if err = config.SetClientSecret("dev", "other"); err != nil {
+    t.Fatalf("SetClientSecret: %v", err)
+  }
+
+  if _, secret := config.GetCurrentOAuthClient(); secret != "other" {
+    t.Errorf("client secret is %q, expected other", secret)
+  }
+
+  if got := readConfigFile(t, paths[0]).Contexts[0].ClusterInfo.ClientSecret; got != "other" {
+    t.Errorf("client secret in the plain config file is %q, expected other", got)
+  }
+
+  if err = config.SetClientSecret("gone", "other"); err == nil {
+    t.Errorf("expected an error setting the client secret of a missing context")
+  }
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+  dir, err := ioutil.TempDir("", "shipyardctl")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  previous := os.Getenv("SHIPYARDCTL_PASSPHRASE")
+  os.Setenv("SHIPYARDCTL_PASSPHRASE", "")
+  defer os.Setenv("SHIPYARDCTL_PASSPHRASE", previous)
+
+  path := filepath.Join(dir, ShipyardctlCredentialsFileName)
+  store := &encryptedFileStore{path: path, passphrase: []byte("passphrase")}
+
+  creds := Credentials{Token: "the-token", RefreshToken: "the-refresh-token", ClientSecret: "s3cret"}
+  if err = store.Set("dev", creds); err != nil {
+    t.Fatalf("Set: %v", err)
+  }
+
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if strings.Contains(string(data), "the-token") || strings.Contains(string(data), "s3cret") {
+    t.Errorf("credentials are readable in %s:\n%s", path, data)
+  }
+
+  // a new process, which has to derive the key again
+  if got, err := (&encryptedFileStore{path: path, passphrase: []byte("passphrase")}).Get("dev"); err != nil || got != creds {
+    t.Errorf("Get = %+v, %v, expected %+v", got, err, creds)
+  }
+
+  if _, err = (&encryptedFileStore{path: path, passphrase: []byte("wrong")}).Get("dev"); err == nil {
+    t.Errorf("expected an error decrypting with the wrong passphrase")
+  }
+
+  if _, err = (&encryptedFileStore{path: path}).Get("dev"); err == nil || !strings.Contains(err.Error(), "Missing passphrase") {
+    t.Errorf("expected an error without a passphrase, got %v", err)
+  }
+
+  if err = store.Delete("dev"); err != nil {
+    t.Fatalf("Delete: %v", err)
+  }
+
+  if _, err = os.Stat(path); !os.IsNotExist(err) {
+    t.Errorf("expected %s to be removed with its last credentials, got %v", path, err)
+  }
+}
+
+func TestEncryptedFileStoreWithKeyFile(t *testing.T) {
+  dir, err := ioutil.TempDir("", "shipyardctl")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  previous := os.Getenv("SHIPYARDCTL_KEY_FILE")
+  os.Setenv("SHIPYARDCTL_KEY_FILE", "")
+  defer os.Setenv("SHIPYARDCTL_KEY_FILE", previous)
+
+  keyFile := filepath.Join(dir, "key")
+  if err = ioutil.WriteFile(keyFile, []byte("a key\n"), 0600); err != nil {
+    t.Fatal(err)
+  }
+
+  path := filepath.Join(dir, ShipyardctlCredentialsFileName)
+  creds := Credentials{Token: "the-token"}
+  if err = (&encryptedFileStore{path: path, keyFile: keyFile}).Set("dev", creds); err != nil {
+    t.Fatalf("Set: %v", err)
+  }
+
+  // the key file wins over a passphrase, and its trailing newline is ignored
+  store := &encryptedFileStore{path: path, keyFile: keyFile, passphrase: []byte("other")}
+  if got, err := store.Get("dev"); err != nil || got != creds {
+    t.Errorf("Get = %+v, %v, expected %+v", got, err, creds)
+  }
+
+  if _, err = (&encryptedFileStore{path: path, passphrase: []byte("other")}).Get("dev"); err == nil {
+    t.Errorf("expected an error decrypting without the key file")
+  }
+}
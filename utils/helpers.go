@@ -10,47 +10,94 @@ import (
   yaml "gopkg.in/yaml.v2"
 )
 
-// ConfigExists checks if the config file exists
+// ConfigPath the config files to use, separated like PATH, set by the --config flag.
+// SHIPYARDCTL_CONFIG is used when empty, then $HOME/.shipyardctl/config.
+var ConfigPath string
+
+// ConfigExists checks if any of the config files exist
 func ConfigExists() (bool, error) {
-  path, err := getConfigPath()
+  paths, err := getConfigPaths()
   if err != nil {
     return false, err
   }
-  return exists(path)
-}
 
-// GetConfigPath exported version of getConfigPath
-func GetConfigPath() string {
-  path, err := getConfigPath()
-  if err != nil {
-    fmt.Println(err)
-    os.Exit(-1)
+  for _, path := range paths {
+    found, err := exists(path)
+    if found || err != nil {
+      return found, err
+    }
   }
 
-  return path
+  return false, nil
 }
 
-// LoadConfig reads the config file into memory
+// GetConfigPath exported version of getConfigPath
+func GetConfigPath() (string, error) {
+  return getConfigPath()
+}
+
+// LoadConfig reads the config files into memory, merging them like kubeconfig does:
+// the first file to define a context or setting wins, and files that do not exist
+// are skipped.
 func LoadConfig() (*Config, error) {
-  path, err := getConfigPath()
+  paths, err := getConfigPaths()
   if err != nil {
     return nil, err
   }
 
   config := Config{}
-  data, err := ioutil.ReadFile(path)
-  if err != nil {
-    return nil, err
-  }
+  for _, path := range paths {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) && len(paths) > 1 {
+      continue
+    } else if err != nil {
+      return nil, err
+    }
 
-  err = yaml.Unmarshal(data, &config)
-  if err != nil {
-    return nil, err
+    data, err = migrateConfig(path, data)
+    if err != nil {
+      return nil, err
+    }
+
+    file := Config{}
+    err = yaml.Unmarshal(data, &file)
+    if err != nil {
+      return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    config.merge(file, path)
   }
 
   return &config, nil
 }
 
+// merge adds the contexts and settings of a config file not defined already
+func (c *Config) merge(file Config, path string) {
+  if c.APIVersion == "" {
+    c.APIVersion = file.APIVersion
+  }
+  if c.CurrentContext == "" {
+    c.CurrentContext = file.CurrentContext
+  }
+  if c.CredentialStore == "" {
+    c.CredentialStore = file.CredentialStore
+  }
+  if c.CredentialKeyFile == "" {
+    c.CredentialKeyFile = file.CredentialKeyFile
+  }
+
+  for _, con := range file.Contexts {
+    if !c.hasContext(con.Name) {
+      con.source = path
+      c.Contexts = append(c.Contexts, con)
+    }
+  }
+
+  settings := file
+  settings.Contexts = nil
+  c.sources = append(c.sources, configSource{path, settings})
+}
+
 func exists(path string) (bool, error) {
   _, err := os.Stat(path)
   if err == nil { return true, nil }
@@ -59,19 +106,53 @@ func exists(path string) (bool, error) {
 }
 
 func homedir() (string, error) {
+  if home := os.Getenv("HOME"); home != "" {
+    return home, nil
+  }
+
   usr, err := user.Current()
   if err != nil {
     return "", err
   }
 
+  if usr.HomeDir == "" {
+    return "", fmt.Errorf("User %s has no home directory", usr.Username)
+  }
+
   return usr.HomeDir, nil
 }
 
+// getConfigPath the config file written to when there are several, the first one
 func getConfigPath() (string, error) {
-  home, err := homedir()
+  paths, err := getConfigPaths()
   if err != nil {
     return "", err
   }
 
-  return filepath.Join(home, ShipyardctlConfigDir, ShipyardctlConfigFileName), err
-}
\ No newline at end of file
+  return paths[0], nil
+}
+
+func getConfigPaths() ([]string, error) {
+  list := ConfigPath
+  if list == "" {
+    list = os.Getenv("SHIPYARDCTL_CONFIG")
+  }
+
+  paths := []string{}
+  for _, path := range filepath.SplitList(list) {
+    if path != "" {
+      paths = append(paths, path)
+    }
+  }
+
+  if len(paths) > 0 {
+    return paths, nil
+  }
+
+  home, err := homedir()
+  if err != nil {
+    return nil, err
+  }
+
+  return []string{filepath.Join(home, ShipyardctlConfigDir, ShipyardctlConfigFileName)}, nil
+}
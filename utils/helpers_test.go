@@ -0,0 +1,156 @@
+package utils
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "testing"
+
+  yaml "gopkg.in/yaml.v2"
+)
+
+// useConfigFiles points ConfigPath at config files with the given contents in a
+// temporary directory, returning their paths and a function removing them
+func useConfigFiles(t *testing.T, contents ...string) ([]string, func()) {
+  dir, err := ioutil.TempDir("", "shipyardctl")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  paths := []string{}
+  for ndx, content := range contents {
+    path := filepath.Join(dir, "config" + strconv.Itoa(ndx))
+    if content != "" {
+      if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatal(err)
+      }
+    }
+
+    paths = append(paths, path)
+  }
+
+  previous := ConfigPath
+  ConfigPath = strings.Join(paths, string(os.PathListSeparator))
+
+  return paths, func() {
+    ConfigPath = previous
+    os.RemoveAll(dir)
+  }
+}
+
+func readConfigFile(t *testing.T, path string) Config {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  config := Config{}
+  if err = yaml.Unmarshal(data, &config); err != nil {
+    t.Fatal(err)
+  }
+
+  return config
+}
+
+func TestLoadConfigMerges(t *testing.T) {
+  _, cleanup := useConfigFiles(t, `apiVersion: v1
+currentcontext: dev
+contexts:
+- name: dev
+  clusterinfo:
+    cluster: https://dev.example.com
+`, "", `apiVersion: v1
+currentcontext: prod
+credentialstore: keyring
+contexts:
+- name: dev
+  clusterinfo:
+    cluster: https://shadowed.example.com
+- name: prod
+  clusterinfo:
+    cluster: https://prod.example.com
+`)
+  defer cleanup()
+
+  config, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  if config.CurrentContext != "dev" {
+    t.Errorf("current context %q, expected the one of the first file", config.CurrentContext)
+  }
+
+  if config.CredentialStore != KeyringCredentialStore {
+    t.Errorf("credential store %q, expected the one of the last file", config.CredentialStore)
+  }
+
+  if len(config.Contexts) != 2 {
+    t.Fatalf("merged %d contexts, expected 2", len(config.Contexts))
+  }
+
+  if target := config.GetCurrentClusterTarget(); target != "https://dev.example.com" {
+    t.Errorf("context dev targets %s, expected the definition of the first file", target)
+  }
+}
+
+func TestSaveWritesContextsBack(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, `apiVersion: v1
+currentcontext: dev
+contexts:
+- name: dev
+  clusterinfo:
+    cluster: https://dev.example.com
+`, `apiVersion: v1
+contexts:
+- name: prod
+  clusterinfo:
+    cluster: https://prod.example.com
+`)
+  defer cleanup()
+
+  config, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  err = config.ModifyContext("prod", func(con *Context) { con.Org = "org1" })
+  if err != nil {
+    t.Fatalf("ModifyContext: %v", err)
+  }
+
+  if err = config.NewContext("new", Cluster{Cluster: "https://new.example.com"}); err != nil {
+    t.Fatalf("NewContext: %v", err)
+  }
+
+  first := readConfigFile(t, paths[0])
+  second := readConfigFile(t, paths[1])
+
+  names := func(c Config) []string {
+    list := []string{}
+    for _, con := range c.Contexts {
+      list = append(list, con.Name)
+    }
+
+    return list
+  }
+
+  if got := strings.Join(names(first), ","); got != "dev,new" {
+    t.Errorf("first file has contexts %s, expected dev,new", got)
+  }
+
+  if got := strings.Join(names(second), ","); got != "prod" || second.Contexts[0].Org != "org1" {
+    t.Errorf("second file has contexts %s with org %q, expected prod with org1", got, second.Contexts[0].Org)
+  }
+}
+
+func TestMissingCurrentContext(t *testing.T) {
+  config := &Config{CurrentContext: "gone", Contexts: []Context{{Name: "dev"}}}
+
+  if config.GetCurrentContext() != nil || config.GetCurrentClusterTarget() != "" ||
+    config.GetCurrentSSOTarget() != "" || config.GetCurrentUsername() != "" {
+    t.Errorf("a missing current context resolved to %+v", config.GetCurrentContext())
+  }
+}
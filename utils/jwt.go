@@ -0,0 +1,62 @@
+package utils
+
+import (
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "strings"
+  "time"
+)
+
+// TokenClaims the claims carried by an Apigee SSO access token
+type TokenClaims struct {
+  ID string `json:"jti"`
+  Subject string `json:"sub"`
+  UserName string `json:"user_name"`
+  Email string `json:"email"`
+  ClientID string `json:"client_id"`
+  Issuer string `json:"iss"`
+  Scope []string `json:"scope"`
+  IssuedAt int64 `json:"iat"`
+  ExpiresAt int64 `json:"exp"`
+}
+
+// DecodeToken reads the claims of a JWT without verifying its signature,
+// which is left to the APIs the token is sent to
+func DecodeToken(token string) (*TokenClaims, error) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return nil, fmt.Errorf("Token is not a JWT")
+  }
+
+  payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+  if err != nil {
+    return nil, fmt.Errorf("Token payload is not valid base64: %v", err)
+  }
+
+  claims := &TokenClaims{}
+  err = json.Unmarshal(payload, claims)
+  if err != nil {
+    return nil, fmt.Errorf("Token payload is not valid JSON: %v", err)
+  }
+
+  return claims, nil
+}
+
+// Expiry the time at which the token expires, zero if it does not say
+func (c *TokenClaims) Expiry() time.Time {
+  if c.ExpiresAt == 0 {
+    return time.Time{}
+  }
+
+  return time.Unix(c.ExpiresAt, 0)
+}
+
+// ExpiresWithin reports whether the token expires within the given duration from now
+func (c *TokenClaims) ExpiresWithin(d time.Duration) bool {
+  if c.ExpiresAt == 0 {
+    return false
+  }
+
+  return time.Now().Add(d).After(c.Expiry())
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+  "encoding/base64"
+  "testing"
+  "time"
+)
+
+func TestDecodeToken(t *testing.T) {
+  header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+  payload := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"abc","user_name":"me@example.com","scope":["openid"],"exp":1500000000}`))
+
+  claims, err := DecodeToken(header + "." + payload + ".signature")
+  if err != nil {
+    t.Fatalf("DecodeToken: %v", err)
+  }
+
+  if claims.ID != "abc" || claims.UserName != "me@example.com" || len(claims.Scope) != 1 || claims.ExpiresAt != 1500000000 {
+    t.Errorf("DecodeToken read %+v", claims)
+  }
+
+  if !claims.Expiry().Equal(time.Unix(1500000000, 0)) || !claims.ExpiresWithin(0) {
+    t.Errorf("token expiring at %d is not expired", claims.ExpiresAt)
+  }
+}
+
+func TestDecodeTokenWithPadding(t *testing.T) {
+  payload := base64.URLEncoding.EncodeToString([]byte(`{"jti":"a"}`))
+
+  claims, err := DecodeToken("h." + payload + ".s")
+  if err != nil {
+    t.Fatalf("DecodeToken: %v", err)
+  }
+
+  if claims.ID != "a" {
+    t.Errorf("DecodeToken read jti %q, expected a", claims.ID)
+  }
+}
+
+func TestDecodeTokenRejectsOtherTokens(t *testing.T) {
+  tokens := map[string]string{
+    "opaque": "not-a-jwt",
+    "bad base64": "h.!!!.s",
+    "bad JSON": "h." + base64.RawURLEncoding.EncodeToString([]byte("{")) + ".s",
+  }
+
+  for kind, token := range tokens {
+    if _, err := DecodeToken(token); err == nil {
+      t.Errorf("DecodeToken accepted a token with %s", kind)
+    }
+  }
+}
+
+func TestTokenWithoutExpiry(t *testing.T) {
+  claims := &TokenClaims{}
+  if !claims.Expiry().IsZero() || claims.ExpiresWithin(time.Hour) {
+    t.Errorf("a token without exp expires at %v", claims.Expiry())
+  }
+}
@@ -0,0 +1,69 @@
+// +build darwin
+
+package utils
+
+import (
+  "bytes"
+  "encoding/hex"
+  "fmt"
+  "os/exec"
+  "strings"
+)
+
+// the login keychain is reached through the security CLI
+
+// exit status of security when the item does not exist
+const errSecItemNotFound = 44
+
+func keyringGet(context string) (string, error) {
+  out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", context, "-w").Output()
+  if notFound(err) {
+    return "", nil
+  } else if err != nil {
+    return "", fmt.Errorf("Keychain error: %v", err)
+  }
+
+  return strings.TrimSpace(string(out)), nil
+}
+
+// keyringSet feeds the command to an interactive security session on stdin,
+// keeping the secret out of the arguments other users can see with ps
+func keyringSet(context string, secret string) error {
+  cmd := exec.Command("security", "-i")
+  cmd.Stdin = bytes.NewBufferString(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+    keychainQuote(keyringService), keychainQuote(context), hex.EncodeToString([]byte(secret))))
+
+  out, err := cmd.CombinedOutput()
+  if err == nil && bytes.Contains(out, []byte("security: ")) { // failed commands of a session exit 0
+    err = fmt.Errorf("%s", bytes.TrimSpace(out))
+  }
+
+  if err != nil {
+    return fmt.Errorf("Keychain error: %v", err)
+  }
+
+  return nil
+}
+
+func keyringDelete(context string) error {
+  err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", context).Run()
+  if err != nil && !notFound(err) {
+    return fmt.Errorf("Keychain error: %v", err)
+  }
+
+  return nil
+}
+
+// keychainQuote quotes an argument of an interactive security session
+func keychainQuote(arg string) string {
+  return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func notFound(err error) bool {
+  exitErr, ok := err.(*exec.ExitError)
+  if !ok {
+    return false
+  }
+
+  return strings.HasSuffix(exitErr.Error(), fmt.Sprintf("exit status %d", errSecItemNotFound))
+}
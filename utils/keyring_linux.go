@@ -0,0 +1,52 @@
+// +build linux
+
+package utils
+
+import (
+  "bytes"
+  "fmt"
+  "os/exec"
+  "strings"
+)
+
+// the Secret Service is reached through secret-tool, the libsecret CLI
+
+func keyringGet(context string) (string, error) {
+  out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "context", context).Output()
+  if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) == 0 {
+    return "", nil // no such secret
+  } else if err != nil {
+    return "", keyringError(err)
+  }
+
+  return strings.TrimSpace(string(out)), nil
+}
+
+func keyringSet(context string, secret string) error {
+  cmd := exec.Command("secret-tool", "store", "--label", "shipyardctl context " + context,
+    "service", keyringService, "context", context)
+  cmd.Stdin = bytes.NewBufferString(secret)
+
+  return keyringError(cmd.Run())
+}
+
+func keyringDelete(context string) error {
+  err := exec.Command("secret-tool", "clear", "service", keyringService, "context", context).Run()
+  if _, ok := err.(*exec.ExitError); ok {
+    return nil // nothing to clear
+  }
+
+  return keyringError(err)
+}
+
+func keyringError(err error) error {
+  if err == nil {
+    return nil
+  }
+
+  if execErr, ok := err.(*exec.Error); ok && execErr.Err == exec.ErrNotFound {
+    return fmt.Errorf("The keyring credential store needs secret-tool, install libsecret-tools")
+  }
+
+  return fmt.Errorf("Keyring error: %v", err)
+}
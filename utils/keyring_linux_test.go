@@ -0,0 +1,80 @@
+package utils
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// fakeSecretTool puts a secret-tool on the PATH that keeps secrets as files in
+// a temporary directory, returning a function restoring the PATH
+func fakeSecretTool(t *testing.T) func() {
+  dir, err := ioutil.TempDir("", "shipyardctl")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  script := `#!/bin/sh
+dir="$(dirname "$0")"
+case "$1" in
+  store) cat > "$dir/secret-$7" ;;
+  lookup) cat "$dir/secret-$5" 2>/dev/null || exit 1 ;;
+  clear) rm "$dir/secret-$5" 2>/dev/null || exit 1 ;;
+esac
+`
+  if err = ioutil.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0700); err != nil {
+    t.Fatal(err)
+  }
+
+  previous := os.Getenv("PATH")
+  os.Setenv("PATH", dir + string(os.PathListSeparator) + previous)
+
+  return func() {
+    os.Setenv("PATH", previous)
+    os.RemoveAll(dir)
+  }
+}
+
+func TestKeyringStore(t *testing.T) {
+  defer fakeSecretTool(t)()
+
+  store := &keyringStore{}
+  if got, err := store.Get("dev"); err != nil || got != (Credentials{}) {
+    t.Errorf("Get of a missing secret = %+v, %v, expected none", got, err)
+  }
+
+  creds := Credentials{Token: "the-token", RefreshToken: "the-refresh-token", ClientSecret: "s3cret"}
+  if err := store.Set("dev", creds); err != nil {
+    t.Fatalf("Set: %v", err)
+  }
+
+  if got, err := store.Get("dev"); err != nil || got != creds {
+    t.Errorf("Get = %+v, %v, expected %+v", got, err, creds)
+  }
+
+  // clearing every credential removes the secret
+  if err := store.Set("dev", Credentials{}); err != nil {
+    t.Fatalf("Set: %v", err)
+  }
+
+  if got, err := store.Get("dev"); err != nil || got != (Credentials{}) {
+    t.Errorf("Get after clearing = %+v, %v, expected none", got, err)
+  }
+
+  if err := store.Delete("dev"); err != nil {
+    t.Errorf("expected deleting a missing secret to succeed, got %v", err)
+  }
+}
+
+func TestKeyringStoreWithoutSecretTool(t *testing.T) {
+  previous := os.Getenv("PATH")
+  os.Setenv("PATH", "")
+  defer os.Setenv("PATH", previous)
+
+  _, err := (&keyringStore{}).Get("dev")
+  if err == nil || !strings.Contains(err.Error(), "install libsecret-tools") {
+    t.Errorf("expected an error telling to install secret-tool, got %v", err)
+  }
+}
@@ -0,0 +1,24 @@
+// +build !linux,!darwin
+
+package utils
+
+import (
+  "fmt"
+  "runtime"
+)
+
+func keyringGet(context string) (string, error) {
+  return "", keyringUnsupported()
+}
+
+func keyringSet(context string, secret string) error {
+  return keyringUnsupported()
+}
+
+func keyringDelete(context string) error {
+  return keyringUnsupported()
+}
+
+func keyringUnsupported() error {
+  return fmt.Errorf("The keyring credential store is not supported on %s, use %s instead", runtime.GOOS, EncryptedFileCredentialStore)
+}
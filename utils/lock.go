@@ -0,0 +1,74 @@
+package utils
+
+import (
+  "fmt"
+  "os"
+  "sort"
+)
+
+// whether this process holds the lock of the config files, which is not reentrant
+var configLocked bool
+
+// lockConfig takes the lock of every config file in use, so that concurrent
+// shipyardctl processes change the config one at a time. The locks are kept
+// in separate .lock files, as the config files themselves are replaced on save,
+// and those are removed again on unlock.
+func lockConfig() (func(), error) {
+  paths, err := getConfigPaths()
+  if err != nil {
+    return nil, err
+  }
+
+  // always lock in the same order, whatever order the files are merged in
+  paths = append([]string{}, paths...)
+  sort.Strings(paths)
+
+  locked := []*os.File{}
+  unlock := func() {
+    for ndx := len(locked) - 1; ndx >= 0; ndx-- {
+      releaseFile(locked[ndx])
+    }
+    configLocked = false
+  }
+
+  for _, path := range paths {
+    f, err := openLockFile(path + ".lock")
+    if os.IsNotExist(err) { // no directory for a config file that is skipped anyway
+      continue
+    } else if err != nil {
+      unlock()
+      return nil, fmt.Errorf("Unable to lock %s: %v", path, err)
+    }
+
+    locked = append(locked, f)
+  }
+
+  configLocked = true
+  return unlock, nil
+}
+
+// openLockFile creates the lock file at path and locks it. The previous holder
+// removes the file on unlock, so the file locked after waiting may no longer be
+// the one at path, in which case it starts over with a new one.
+func openLockFile(path string) (*os.File, error) {
+  for {
+    f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+    if err != nil {
+      return nil, err
+    }
+
+    if err = lockFile(f); err != nil {
+      f.Close()
+      return nil, err
+    }
+
+    current, err := os.Stat(path)
+    locked, lockedErr := f.Stat()
+    if err == nil && lockedErr == nil && os.SameFile(current, locked) {
+      return f, nil
+    }
+
+    unlockFile(f)
+    f.Close()
+  }
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+  "os"
+  "path/filepath"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+func TestUpdateMergesChanges(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, `apiVersion: v1
+currentcontext: dev
+contexts:
+- name: dev
+  clusterinfo:
+    cluster: https://dev.example.com
+`)
+  defer cleanup()
+
+  first, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  second, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  if err = first.ModifyContext("dev", func(con *Context) { con.Org = "org1" }); err != nil {
+    t.Fatalf("ModifyContext: %v", err)
+  }
+
+  // the second process still has the contents from before the first one's change
+  if err = second.NewContext("new", Cluster{Cluster: "https://new.example.com"}); err != nil {
+    t.Fatalf("NewContext: %v", err)
+  }
+
+  saved := readConfigFile(t, paths[0])
+  if len(saved.Contexts) != 2 || saved.Contexts[0].Org != "org1" || saved.Contexts[1].Name != "new" {
+    t.Errorf("expected both changes to be saved, got %+v", saved.Contexts)
+  }
+
+  if _, err = os.Stat(paths[0] + ".lock"); !os.IsNotExist(err) {
+    t.Errorf("expected the lock file to be removed, got %v", err)
+  }
+}
+
+func TestLockConfigRemovesLockFile(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, "apiVersion: v1\n")
+  defer cleanup()
+
+  unlock, err := lockConfig()
+  if err != nil {
+    t.Fatalf("lockConfig: %v", err)
+  }
+
+  if _, err = os.Stat(paths[0] + ".lock"); err != nil {
+    t.Errorf("expected a lock file while locked: %v", err)
+  }
+
+  unlock()
+
+  if _, err = os.Stat(paths[0] + ".lock"); !os.IsNotExist(err) {
+    t.Errorf("expected the lock file to be removed on unlock, got %v", err)
+  }
+}
+
+func TestOpenLockFileExcludesWaiters(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, "")
+  defer cleanup()
+
+  path := filepath.Join(filepath.Dir(paths[0]), "config.lock")
+
+  var holders, overlaps int32
+  wg := sync.WaitGroup{}
+  for n := 0; n < 8; n++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      for i := 0; i < 20; i++ {
+        f, err := openLockFile(path)
+        if err != nil {
+          t.Error(err)
+          return
+        }
+
+        // waiters on a file removed by its previous holder must not hold a lock as well
+        if atomic.AddInt32(&holders, 1) > 1 {
+          atomic.AddInt32(&overlaps, 1)
+        }
+        time.Sleep(100 * time.Microsecond)
+        atomic.AddInt32(&holders, -1)
+
+        releaseFile(f)
+      }
+    }()
+  }
+
+  wg.Wait()
+
+  if overlaps > 0 {
+    t.Errorf("the lock was held %d times by more than one waiter", overlaps)
+  }
+}
@@ -0,0 +1,26 @@
+// +build !windows
+
+package utils
+
+import (
+  "os"
+
+  "golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive advisory lock on the file, waiting for other holders
+func lockFile(f *os.File) error {
+  return unix.Flock(int(f.Fd()), unix.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+  return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// releaseFile removes the lock file before unlocking it, so that whoever waits
+// for it notices and locks a new one
+func releaseFile(f *os.File) {
+  os.Remove(f.Name())
+  unlockFile(f)
+  f.Close()
+}
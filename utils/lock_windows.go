@@ -0,0 +1,46 @@
+package utils
+
+import (
+  "os"
+  "unsafe"
+
+  "golang.org/x/sys/windows"
+)
+
+const lockfileExclusiveLock = 0x2
+
+var (
+  kernel32 = windows.NewLazySystemDLL("kernel32.dll")
+  procLockFileEx = kernel32.NewProc("LockFileEx")
+  procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile takes an exclusive lock on the first byte of the file, waiting for other holders
+func lockFile(f *os.File) error {
+  overlapped := &windows.Overlapped{}
+  r1, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+  if r1 == 0 {
+    return err
+  }
+
+  return nil
+}
+
+// releaseFile unlocks and removes the lock file. Open files can't be removed on
+// Windows, so the file stays while another process waits for it, to be removed
+// by the last one.
+func releaseFile(f *os.File) {
+  unlockFile(f)
+  f.Close()
+  os.Remove(f.Name())
+}
+
+func unlockFile(f *os.File) error {
+  overlapped := &windows.Overlapped{}
+  r1, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+  if r1 == 0 {
+    return err
+  }
+
+  return nil
+}
@@ -0,0 +1,276 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "net/url"
+  "os"
+  "reflect"
+  "sort"
+  "strings"
+
+  yaml "gopkg.in/yaml.v2"
+)
+
+const (
+  // ConfigAPIVersion version of the config file schema written by this shipyardctl
+  ConfigAPIVersion = "v1"
+
+  // version of config files written before the schema was versioned
+  legacyConfigAPIVersion = "v0"
+)
+
+// configMigration upgrades a raw config file from one schema version to the next
+type configMigration struct {
+  from string
+  to string
+  migrate func(raw map[interface{}]interface{}) error
+}
+
+// configMigrations the upgrade chain, applied in order to files older than ConfigAPIVersion
+var configMigrations = []configMigration{
+  // everything added before versioning (OAuth clients, refresh tokens, expiry,
+  // credential stores, org and environment defaults) is optional, so v0 files
+  // only need to be stamped
+  {legacyConfigAPIVersion, "v1", func(raw map[interface{}]interface{}) error { return nil }},
+}
+
+// migrateConfig upgrades the config file data to ConfigAPIVersion, keeping a
+// backup copy of the file as it was. Data that is current is returned as is.
+func migrateConfig(path string, data []byte) ([]byte, error) {
+  raw := map[interface{}]interface{}{}
+  err := yaml.Unmarshal(data, &raw)
+  if err != nil {
+    return nil, fmt.Errorf("%s: %v", path, err)
+  }
+
+  version := configVersion(raw)
+  if version == ConfigAPIVersion {
+    return data, nil
+  }
+
+  if !configLocked {
+    unlock, err := lockConfig()
+    if err != nil {
+      return nil, err
+    }
+    defer unlock()
+
+    // another process may have migrated the file in the meantime
+    if data, err = ioutil.ReadFile(path); err != nil {
+      return nil, err
+    }
+
+    return migrateConfig(path, data)
+  }
+
+  from := version
+  for _, m := range configMigrations {
+    if m.from != version {
+      continue
+    }
+
+    if err = m.migrate(raw); err != nil {
+      return nil, fmt.Errorf("%s: migrating from %s to %s: %v", path, m.from, m.to, err)
+    }
+
+    version = m.to
+  }
+
+  if version != ConfigAPIVersion {
+    return nil, fmt.Errorf("%s has apiVersion %s, which this shipyardctl does not support (expected %s). Please upgrade shipyardctl.",
+      path, from, ConfigAPIVersion)
+  }
+
+  raw["apiVersion"] = ConfigAPIVersion
+  migrated, err := yaml.Marshal(raw)
+  if err != nil {
+    return nil, err
+  }
+
+  backup := path + "." + from + ".bak"
+  if err = writeFilePrivate(backup, data); err != nil {
+    return nil, fmt.Errorf("Unable to back up %s before migrating it: %v", path, err)
+  }
+
+  if err = writeFilePrivate(path, migrated); err != nil {
+    return nil, err
+  }
+
+  fmt.Fprintf(os.Stderr, "Upgraded %s from apiVersion %s to %s, the old file is kept at %s\n", path, from, ConfigAPIVersion, backup)
+  return migrated, nil
+}
+
+func canMigrate(version string) bool {
+  for _, m := range configMigrations {
+    if m.from == version {
+      return true
+    }
+  }
+
+  return false
+}
+
+func configVersion(raw map[interface{}]interface{}) string {
+  if version, ok := raw["apiVersion"].(string); ok && version != "" {
+    return version
+  }
+
+  return legacyConfigAPIVersion
+}
+
+// ValidateConfig checks the config files for unknown keys, unsupported versions,
+// malformed URLs and a current context that does not exist, returning the problems found
+func ValidateConfig() ([]string, error) {
+  paths, err := getConfigPaths()
+  if err != nil {
+    return nil, err
+  }
+
+  problems := []string{}
+  merged := Config{}
+  for _, path := range paths {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) && len(paths) > 1 {
+      continue
+    } else if err != nil {
+      return nil, err
+    }
+
+    raw := map[interface{}]interface{}{}
+    if err = yaml.Unmarshal(data, &raw); err != nil {
+      problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+      continue
+    }
+
+    if version := configVersion(raw); version != ConfigAPIVersion {
+      if canMigrate(version) {
+        problems = append(problems, fmt.Sprintf("%s: apiVersion is %s, it is upgraded to %s on next use", path, version, ConfigAPIVersion))
+      } else {
+        problems = append(problems, fmt.Sprintf("%s: apiVersion is %s, expected %s", path, version, ConfigAPIVersion))
+      }
+    }
+
+    for _, key := range unknownKeys(raw, reflect.TypeOf(Config{}), "") {
+      problems = append(problems, fmt.Sprintf("%s: unknown key %s", path, key))
+    }
+
+    file := Config{}
+    if err = yaml.Unmarshal(data, &file); err != nil {
+      problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+      continue
+    }
+
+    names := map[string]bool{}
+    for _, con := range file.Contexts {
+      if names[con.Name] {
+        problems = append(problems, fmt.Sprintf("%s: context %s is defined more than once", path, con.Name))
+      }
+      names[con.Name] = true
+
+      for _, target := range []struct{ key, value string }{
+        {"cluster", con.ClusterInfo.Cluster},
+        {"sso", con.ClusterInfo.SSO},
+      } {
+        if err := checkURL(target.value); err != nil {
+          problems = append(problems, fmt.Sprintf("%s: context %s: %s %v", path, con.Name, target.key, err))
+        }
+      }
+
+      if _, err := con.ClusterInfo.TLSConfig(); err != nil {
+        problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+      }
+    }
+
+    if _, err = NewCredentialStore(file.CredentialStore, &file); err != nil {
+      problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+    }
+
+    merged.merge(file, path)
+  }
+
+  if merged.CurrentContext == "" {
+    problems = append(problems, "no current context is set")
+  } else if !merged.hasContext(merged.CurrentContext) {
+    problems = append(problems, fmt.Sprintf("current context %s does not exist", merged.CurrentContext))
+  }
+
+  return problems, nil
+}
+
+// checkURL requires an absolute http(s) URL
+func checkURL(value string) error {
+  if value == "" {
+    return fmt.Errorf("is missing")
+  }
+
+  u, err := url.Parse(value)
+  if err != nil {
+    return fmt.Errorf("%q is not a valid URL: %v", value, err)
+  }
+
+  if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return fmt.Errorf("%q is not an http(s) URL with a host", value)
+  }
+
+  return nil
+}
+
+// unknownKeys lists the keys of the raw YAML that do not map to a field of the given struct type
+func unknownKeys(raw map[interface{}]interface{}, t reflect.Type, prefix string) []string {
+  fields := yamlFields(t)
+  unknown := []string{}
+
+  for k, v := range raw {
+    key := fmt.Sprint(k)
+    field, ok := fields[key]
+    if !ok {
+      unknown = append(unknown, prefix + key)
+      continue
+    }
+
+    switch field.Kind() {
+    case reflect.Struct:
+      if child, ok := v.(map[interface{}]interface{}); ok {
+        unknown = append(unknown, unknownKeys(child, field, prefix + key + ".")...)
+      }
+    case reflect.Slice:
+      if field.Elem().Kind() != reflect.Struct {
+        continue
+      }
+
+      items, _ := v.([]interface{})
+      for ndx, item := range items {
+        if child, ok := item.(map[interface{}]interface{}); ok {
+          unknown = append(unknown, unknownKeys(child, field.Elem(), fmt.Sprintf("%s%s[%d].", prefix, key, ndx))...)
+        }
+      }
+    }
+  }
+
+  sort.Strings(unknown)
+  return unknown
+}
+
+// yamlFields maps the YAML keys of a struct type to the types of its fields,
+// following the naming rules of yaml.v2
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+  fields := map[string]reflect.Type{}
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" { // unexported
+      continue
+    }
+
+    key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+    if key == "-" {
+      continue
+    } else if key == "" {
+      key = strings.ToLower(f.Name)
+    }
+
+    fields[key] = f.Type
+  }
+
+  return fields
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+  "io/ioutil"
+  "strings"
+  "testing"
+)
+
+const legacyConfig = `currentcontext: default
+contexts:
+- name: default
+  clusterinfo:
+    cluster: https://shipyard.apigee.com
+    sso: https://login.apigee.com
+  userinfo:
+    username: me@example.com
+    token: abc
+`
+
+func TestMigrateConfig(t *testing.T) {
+  paths, cleanup := useConfigFiles(t, legacyConfig)
+  defer cleanup()
+
+  migrated, err := migrateConfig(paths[0], []byte(legacyConfig))
+  if err != nil {
+    t.Fatalf("migrateConfig: %v", err)
+  }
+
+  if !strings.Contains(string(migrated), "apiVersion: " + ConfigAPIVersion) {
+    t.Errorf("migrated config is not stamped with %s:\n%s", ConfigAPIVersion, migrated)
+  }
+
+  written, err := ioutil.ReadFile(paths[0])
+  if err != nil || string(written) != string(migrated) {
+    t.Errorf("the migrated config was not written back: %v", err)
+  }
+
+  backup, err := ioutil.ReadFile(paths[0] + "." + legacyConfigAPIVersion + ".bak")
+  if err != nil || string(backup) != legacyConfig {
+    t.Errorf("the legacy config was not backed up: %v", err)
+  }
+
+  config, err := LoadConfig()
+  if err != nil {
+    t.Fatalf("LoadConfig: %v", err)
+  }
+
+  if config.GetCurrentUsername() != "me@example.com" || config.GetCurrentToken() != "abc" {
+    t.Errorf("migration lost the user info: %+v", config.Contexts)
+  }
+}
+
+func TestMigrateConfigKeepsCurrentFiles(t *testing.T) {
+  current := "apiVersion: " + ConfigAPIVersion + "\ncurrentcontext: default\n"
+  paths, cleanup := useConfigFiles(t, current)
+  defer cleanup()
+
+  data, err := migrateConfig(paths[0], []byte(current))
+  if err != nil {
+    t.Fatalf("migrateConfig: %v", err)
+  }
+
+  if string(data) != current {
+    t.Errorf("a current config was changed:\n%s", data)
+  }
+
+  if _, err = ioutil.ReadFile(paths[0] + "." + legacyConfigAPIVersion + ".bak"); err == nil {
+    t.Errorf("a current config was backed up")
+  }
+}
+
+func TestMigrateConfigRejectsNewerVersions(t *testing.T) {
+  newer := "apiVersion: v99\n"
+  paths, cleanup := useConfigFiles(t, newer)
+  defer cleanup()
+
+  _, err := migrateConfig(paths[0], []byte(newer))
+  if err == nil || !strings.Contains(err.Error(), "v99") {
+    t.Errorf("migrateConfig accepted apiVersion v99: %v", err)
+  }
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+  "crypto/tls"
+  "crypto/x509"
+  "fmt"
+  "io/ioutil"
+)
+
+// HasTLSSettings reports whether the cluster changes how servers are verified
+// or how the client authenticates
+func (c Cluster) HasTLSSettings() bool {
+  return c.CertificateAuthority != "" || c.ClientCertificate != "" || c.ClientKey != "" || c.InsecureSkipTLSVerify
+}
+
+// TLSConfig builds the TLS settings of the cluster, trusting the CA bundle on
+// top of the system roots. It returns nil when the cluster has no TLS settings.
+func (c Cluster) TLSConfig() (*tls.Config, error) {
+  if !c.HasTLSSettings() {
+    return nil, nil
+  }
+
+  config := &tls.Config{InsecureSkipVerify: c.InsecureSkipTLSVerify}
+
+  if c.CertificateAuthority != "" {
+    pem, err := ioutil.ReadFile(c.CertificateAuthority)
+    if err != nil {
+      return nil, fmt.Errorf("Unable to read the certificate authority of context %s: %v", c.Name, err)
+    }
+
+    pool, err := x509.SystemCertPool()
+    if err != nil || pool == nil {
+      pool = x509.NewCertPool()
+    }
+
+    if !pool.AppendCertsFromPEM(pem) {
+      return nil, fmt.Errorf("No certificates found in %s", c.CertificateAuthority)
+    }
+
+    config.RootCAs = pool
+  }
+
+  if c.ClientCertificate != "" || c.ClientKey != "" {
+    if c.ClientCertificate == "" || c.ClientKey == "" {
+      return nil, fmt.Errorf("Context %s needs both a client certificate and a client key", c.Name)
+    }
+
+    cert, err := tls.LoadX509KeyPair(c.ClientCertificate, c.ClientKey)
+    if err != nil {
+      return nil, fmt.Errorf("Unable to load the client certificate of context %s: %v", c.Name, err)
+    }
+
+    config.Certificates = []tls.Certificate{cert}
+  }
+
+  return config, nil
+}
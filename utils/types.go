@@ -5,12 +5,22 @@ type Cluster struct {
   Name string
   Cluster string
   SSO string
+  ClientID string // OAuth client used to login at SSO, edgecli if empty
+  ClientSecret string // only with the plain credential store, see Credentials
+
+  // TLS settings used to reach the cluster and SSO targets, e.g. of a dev cluster
+  CertificateAuthority string // PEM bundle of additional trusted CAs
+  ClientCertificate string // PEM client certificate
+  ClientKey string // PEM key of the client certificate
+  InsecureSkipTLSVerify bool // accept any server certificate
 }
 
 // User representation of a user's credentials
 type User struct {
   Username string
   Token string
+  RefreshToken string
+  ExpiresAt int64 // unix time at which Token expires, 0 if unknown
 }
 
 // Context a named combination of user creds and cluster info
@@ -18,10 +28,28 @@ type Context struct {
   Name string
   ClusterInfo Cluster
   UserInfo User
+  Org string // default Apigee org
+  Environment string // default environment name, "env1" or "org1:env1"
+
+  source string // config file the context was read from
 }
 
 // Config shipyardctl configuration object
 type Config struct {
+  APIVersion string `yaml:"apiVersion"` // version of the schema, see ConfigAPIVersion
   CurrentContext string // name of current Context
   Contexts []Context
+  CredentialStore string // where tokens are kept, plain if empty
+  CredentialKeyFile string // key file of the encrypted-file credential store
+
+  savedContext string // current Context in the file while overridden
+  store CredentialStore
+  sources []configSource // config files merged into this one, in order
+  inMemory bool // not backed by any file, changes are lost on exit
+}
+
+// configSource a config file merged into the config, with its own settings
+type configSource struct {
+  path string
+  settings Config // without contexts
 }
\ No newline at end of file